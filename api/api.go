@@ -1,12 +1,16 @@
 package api
 
 import (
+	"context"
 	"github.com/ikuiki/wwdk/datastruct"
 	"github.com/ikuiki/wwdk/tool"
 	"github.com/pkg/errors"
+	"io"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"path"
+	"reflect"
 	"time"
 )
 
@@ -16,60 +20,181 @@ type WechatwebAPI interface {
 
 	// JsLogin 获取uuid
 	JsLogin() (uuid string, body []byte, err error)
+	// JsLoginContext 同JsLogin，可通过ctx取消请求
+	JsLoginContext(ctx context.Context) (uuid string, body []byte, err error)
 	// Login 等待用户扫码登陆
 	Login(uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error)
+	// LoginContext 同Login，可通过ctx取消请求
+	LoginContext(ctx context.Context, uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error)
+	// PushLogin 使用上次登陆的Wxuin向手机推送登陆确认，返回用于等待确认的uuid
+	PushLogin() (uuid string, body []byte, err error)
+	// PushLoginContext 同PushLogin，可通过ctx取消请求
+	PushLoginContext(ctx context.Context) (uuid string, body []byte, err error)
 	// WebwxNewLoginPage 获取登陆凭据
 	WebwxNewLoginPage(redirectURL string) (body []byte, err error)
+	// WebwxNewLoginPageContext 同WebwxNewLoginPage，可通过ctx取消请求
+	WebwxNewLoginPageContext(ctx context.Context, redirectURL string) (body []byte, err error)
 	// WebwxInit 初始化微信
 	WebwxInit() (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error)
+	// WebwxInitContext 同WebwxInit，可通过ctx取消请求
+	WebwxInitContext(ctx context.Context) (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error)
 	// Logout 退出登录
 	Logout() (body []byte, err error)
+	// LogoutContext 同Logout，可通过ctx取消请求
+	LogoutContext(ctx context.Context) (body []byte, err error)
 
 	// 联系人部分
 
 	// GetContact 获取联系人
 	GetContact() (contactList []datastruct.Contact, body []byte, err error)
+	// GetContactContext 同GetContact，可通过ctx取消请求
+	GetContactContext(ctx context.Context) (contactList []datastruct.Contact, body []byte, err error)
+	// GetContactPage 获取一页联系人
+	GetContactPage(seq int64) (contactList []datastruct.Contact, nextSeq int64, body []byte, err error)
+	// GetContactPageContext 同GetContactPage，可通过ctx取消请求
+	GetContactPageContext(ctx context.Context, seq int64) (contactList []datastruct.Contact, nextSeq int64, body []byte, err error)
 	// BatchGetContact 获取群聊的成员
 	BatchGetContact(contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error)
+	// BatchGetContactContext 同BatchGetContact，可通过ctx取消请求
+	BatchGetContactContext(ctx context.Context, contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error)
 	// ModifyUserRemakName 修改联系人备注
 	ModifyUserRemakName(userName, remarkName string) (body []byte, err error)
+	// ModifyUserRemakNameContext 同ModifyUserRemakName，可通过ctx取消请求
+	ModifyUserRemakNameContext(ctx context.Context, userName, remarkName string) (body []byte, err error)
+	// VerifyUser 好友验证（接受好友请求）
+	VerifyUser(opcode datastruct.VerifyUserOpcode, userName, ticket string) (body []byte, err error)
+	// VerifyUserContext 同VerifyUser，可通过ctx取消请求
+	VerifyUserContext(ctx context.Context, opcode datastruct.VerifyUserOpcode, userName, ticket string) (body []byte, err error)
 
 	// 聊天室部分
 
 	// ModifyChatRoomTopic 修改聊天室标题
 	ModifyChatRoomTopic(userName, newTopic string) (body []byte, err error)
+	// ModifyChatRoomTopicContext 同ModifyChatRoomTopic，可通过ctx取消请求
+	ModifyChatRoomTopicContext(ctx context.Context, userName, newTopic string) (body []byte, err error)
+	// CreateChatRoom 创建群聊
+	CreateChatRoom(topic string, memberUserNames []string) (chatRoomName string, body []byte, err error)
+	// CreateChatRoomContext 同CreateChatRoom，可通过ctx取消请求
+	CreateChatRoomContext(ctx context.Context, topic string, memberUserNames []string) (chatRoomName string, body []byte, err error)
+	// AddChatRoomMember 添加群成员
+	AddChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error)
+	// AddChatRoomMemberContext 同AddChatRoomMember，可通过ctx取消请求
+	AddChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error)
+	// InviteChatRoomMember 邀请群成员
+	InviteChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error)
+	// InviteChatRoomMemberContext 同InviteChatRoomMember，可通过ctx取消请求
+	InviteChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error)
+	// DelChatRoomMember 移除群成员
+	DelChatRoomMember(chatRoomName string, userNames []string) (body []byte, err error)
+	// DelChatRoomMemberContext 同DelChatRoomMember，可通过ctx取消请求
+	DelChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (body []byte, err error)
 
 	// 同步部分
 
 	// SyncCheck 检查同步
 	SyncCheck() (retCode, selector string, body []byte, err error)
+	// SyncCheckContext 同SyncCheck，可通过ctx取消请求
+	SyncCheckContext(ctx context.Context) (retCode, selector string, body []byte, err error)
 	// WebwxSync 同步消息
 	WebwxSync() (modContacts []datastruct.Contact,
 		delContacts []datastruct.WebwxSyncRespondDelContactListItem,
 		addMessages []datastruct.Message,
 		body []byte, err error)
+	// WebwxSyncContext 同WebwxSync，可通过ctx取消请求
+	WebwxSyncContext(ctx context.Context) (modContacts []datastruct.Contact,
+		delContacts []datastruct.WebwxSyncRespondDelContactListItem,
+		addMessages []datastruct.Message,
+		body []byte, err error)
+	// WebwxSyncDetail 同步消息，同时返回群成员与当前用户资料的变更
+	WebwxSyncDetail() (result SyncResult, body []byte, err error)
+	// WebwxSyncDetailContext 同WebwxSyncDetail，可通过ctx取消请求
+	WebwxSyncDetailContext(ctx context.Context) (result SyncResult, body []byte, err error)
 
 	// 发送部分
 
 	// StatusNotify 消息已读通知
 	StatusNotify(fromUserName, toUserName string, code int64) (body []byte, err error)
+	// StatusNotifyContext 同StatusNotify，可通过ctx取消请求
+	StatusNotifyContext(ctx context.Context, fromUserName, toUserName string, code int64) (body []byte, err error)
 	// SendTextMessage 发送消息
 	SendTextMessage(fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error)
+	// SendTextMessageContext 同SendTextMessage，可通过ctx取消请求
+	SendTextMessageContext(ctx context.Context, fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error)
 	// SendRevokeMessage 撤回消息
 	SendRevokeMessage(toUserName, svrMsgID, clientMsgID string) (body []byte, err error)
+	// SendRevokeMessageContext 同SendRevokeMessage，可通过ctx取消请求
+	SendRevokeMessageContext(ctx context.Context, toUserName, svrMsgID, clientMsgID string) (body []byte, err error)
+	// UploadMedia 上传文件
+	UploadMedia(fromUserName, toUserName, fileName string, file io.ReadSeeker) (mediaID string, body []byte, err error)
+	// UploadMediaContext 同UploadMedia，可通过ctx取消请求
+	UploadMediaContext(ctx context.Context, fromUserName, toUserName, fileName string, file io.ReadSeeker) (mediaID string, body []byte, err error)
+	// SendImageMessage 发送图片消息
+	SendImageMessage(fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error)
+	// SendImageMessageContext 同SendImageMessage，可通过ctx取消请求
+	SendImageMessageContext(ctx context.Context, fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error)
+	// SendVideoMessage 发送视频消息
+	SendVideoMessage(fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error)
+	// SendVideoMessageContext 同SendVideoMessage，可通过ctx取消请求
+	SendVideoMessageContext(ctx context.Context, fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error)
+	// SendFileMessage 发送文件消息
+	SendFileMessage(fromUserName, toUserName, mediaID, fileName string, fileSize int64) (MsgID, LocalID string, body []byte, err error)
+	// SendFileMessageContext 同SendFileMessage，可通过ctx取消请求
+	SendFileMessageContext(ctx context.Context, fromUserName, toUserName, mediaID, fileName string, fileSize int64) (MsgID, LocalID string, body []byte, err error)
+	// SendEmoticonMessage 发送动图消息
+	SendEmoticonMessage(fromUserName, toUserName, mediaID, emoticonMd5 string) (MsgID, LocalID string, body []byte, err error)
+	// SendEmoticonMessageContext 同SendEmoticonMessage，可通过ctx取消请求
+	SendEmoticonMessageContext(ctx context.Context, fromUserName, toUserName, mediaID, emoticonMd5 string) (MsgID, LocalID string, body []byte, err error)
 
 	// 接收部分
 
 	// SaveMessageImage 下载图片消息
 	SaveMessageImage(msgID string) (imgData []byte, err error)
+	// SaveMessageImageContext 同SaveMessageImage，可通过ctx取消请求
+	SaveMessageImageContext(ctx context.Context, msgID string) (imgData []byte, err error)
 	// SaveMessageVoice 下载音频消息
 	SaveMessageVoice(msgID string) (voiceData []byte, err error)
+	// SaveMessageVoiceContext 同SaveMessageVoice，可通过ctx取消请求
+	SaveMessageVoiceContext(ctx context.Context, msgID string) (voiceData []byte, err error)
 	// SaveMessageVideo 下载视频消息
 	SaveMessageVideo(msgID string) (videoData []byte, err error)
+	// SaveMessageVideoContext 同SaveMessageVideo，可通过ctx取消请求
+	SaveMessageVideoContext(ctx context.Context, msgID string) (videoData []byte, err error)
+	// SaveMessageFile 下载文件消息的附件
+	SaveMessageFile(mediaID, fileName, fromUserName string) (fileData []byte, err error)
+	// SaveMessageFileContext 同SaveMessageFile，可通过ctx取消请求
+	SaveMessageFileContext(ctx context.Context, mediaID, fileName, fromUserName string) (fileData []byte, err error)
 	// SaveContactImg 保存联系人头像
 	SaveContactImg(headImgURL string) (imgData []byte, err error)
+	// SaveContactImgContext 同SaveContactImg，可通过ctx取消请求
+	SaveContactImgContext(ctx context.Context, headImgURL string) (imgData []byte, err error)
 	// SaveMemberImg 保存群成员的头像
 	SaveMemberImg(userName, chatroomID string) (imgData []byte, err error)
+	// SaveMemberImgContext 同SaveMemberImg，可通过ctx取消请求
+	SaveMemberImgContext(ctx context.Context, userName, chatroomID string) (imgData []byte, err error)
+	// StreamMessageImage 以流的方式下载图片消息
+	StreamMessageImage(msgID string) (stream *MediaStream, err error)
+	// StreamMessageImageContext 同StreamMessageImage，可通过ctx取消请求
+	StreamMessageImageContext(ctx context.Context, msgID string) (stream *MediaStream, err error)
+	// StreamMessageVoice 以流的方式下载音频消息
+	StreamMessageVoice(msgID string) (stream *MediaStream, err error)
+	// StreamMessageVoiceContext 同StreamMessageVoice，可通过ctx取消请求
+	StreamMessageVoiceContext(ctx context.Context, msgID string) (stream *MediaStream, err error)
+	// StreamMessageVideo 以流的方式下载视频消息
+	StreamMessageVideo(msgID string) (stream *MediaStream, err error)
+	// StreamMessageVideoContext 同StreamMessageVideo，可通过ctx取消请求
+	StreamMessageVideoContext(ctx context.Context, msgID string) (stream *MediaStream, err error)
+	// StreamMessageFile 以流的方式下载文件消息的附件
+	StreamMessageFile(mediaID, fileName, fromUserName string) (stream *MediaStream, err error)
+	// StreamMessageFileContext 同StreamMessageFile，可通过ctx取消请求
+	StreamMessageFileContext(ctx context.Context, mediaID, fileName, fromUserName string) (stream *MediaStream, err error)
+	// StreamContactImg 以流的方式下载联系人头像
+	StreamContactImg(headImgURL string) (stream *MediaStream, err error)
+	// StreamContactImgContext 同StreamContactImg，可通过ctx取消请求
+	StreamContactImgContext(ctx context.Context, headImgURL string) (stream *MediaStream, err error)
+	// StreamMemberImg 以流的方式下载群成员的头像
+	StreamMemberImg(userName, chatroomID string) (stream *MediaStream, err error)
+	// StreamMemberImgContext 同StreamMemberImg，可通过ctx取消请求
+	StreamMemberImgContext(ctx context.Context, userName, chatroomID string) (stream *MediaStream, err error)
 
 	// 序列号与反序列化
 
@@ -83,17 +208,22 @@ type WechatwebAPI interface {
 
 // wechatwebAPI 微信网页版api
 type wechatwebAPI struct {
+	uploadCount           int64 // 上传文件计数器，用于生成上传时的文件id，需原子操作，放在首位以保证64位对齐
 	userAgent             string
-	apiDomain             string // 当前的apiDomain，从用户扫码登陆后返回的RedirectURL中解析
-	client                *http.Client
-	deviceID              string // 由客户端生成，为e+15位随机数
+	apiDomain             string       // 当前的apiDomain，从用户扫码登陆后返回的RedirectURL中解析
+	client                *http.Client // 普通请求使用的client，带有整体的超时时间
+	streamClient          *http.Client // 下载媒体文件使用的client，与client共用Transport与cookie，但不限制整体的超时时间
+	deviceID              string       // 由客户端生成，为e+15位随机数
 	loginInfo             LoginInfo
-	loginModifyNotifyChan chan<- bool // 如果登陆消息发生变更，则向此chan中插入一个值
+	loginModifyNotifyChan chan<- bool      // 如果登陆消息发生变更，则向此chan中插入一个值
+	endpointResolver      EndpointResolver // 子系统地址解析器，决定各个子系统请求的scheme与host
+	retryPolicy           *RetryPolicy     // 请求失败时的重试策略
+	breaker               *circuitBreaker  // 接口熔断器，接口返回操作频繁后暂停请求该接口
 }
 
 // MustNewWechatwebAPI 假定一定能创建创建WechatwebAPI
-func MustNewWechatwebAPI() (wechatAPI WechatwebAPI) {
-	wechatAPI, err := NewWechatwebAPI()
+func MustNewWechatwebAPI(configs ...interface{}) (wechatAPI WechatwebAPI) {
+	wechatAPI, err := NewWechatwebAPI(configs...)
 	if err != nil {
 		panic(err)
 	}
@@ -101,13 +231,14 @@ func MustNewWechatwebAPI() (wechatAPI WechatwebAPI) {
 }
 
 // NewWechatwebAPI 创建WechatwebAPI
-func NewWechatwebAPI() (wechatAPI WechatwebAPI, err error) {
+// @param configs 可选配置，目前支持：EndpointResolver、http.RoundTripper、*RetryPolicy、ProxyURL、Timeout
+func NewWechatwebAPI(configs ...interface{}) (wechatAPI WechatwebAPI, err error) {
 	// 创建cookie jar用于持久化cookie
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		return
 	}
-	return &wechatwebAPI{
+	a := &wechatwebAPI{
 		userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/56.0.2924.87 Safari/537.36",
 		deviceID:  "e" + tool.GetRandomStringFromNum(15),
 		apiDomain: "wx.qq.com", // 默认域名
@@ -117,17 +248,50 @@ func NewWechatwebAPI() (wechatAPI WechatwebAPI, err error) {
 					Timeout: 1 * time.Minute,
 				}).Dial,
 				TLSHandshakeTimeout: 1 * time.Minute,
+				// 整体的超时时间由client.Timeout控制，下载媒体文件时读取响应体的时间由各个请求的context控制
+				ResponseHeaderTimeout: 1 * time.Minute,
 				// TLSClientConfig: &tls.Config{
 				// 	InsecureSkipVerify: true, // 跳过https证书验证
 				// },
-				// Proxy: func(_ *http.Request) (*url.URL, error) {
-				// 	return url.Parse("http://127.0.0.1:8888") //根据定义Proxy func(*Request) (*url.URL, error)这里要返回url.URL
-				// },
+				// 代理通过ProxyURL配置设置
 			},
 			Jar:     jar,
-			Timeout: 1 * time.Minute,
+			Timeout: DefaultTimeout,
 		},
-	}, nil
+		endpointResolver: defaultEndpointResolver{},
+		retryPolicy:      DefaultRetryPolicy(),
+		breaker:          &circuitBreaker{},
+	}
+	var proxy ProxyURL
+	for _, c := range configs {
+		switch c.(type) {
+		case EndpointResolver:
+			a.endpointResolver = c.(EndpointResolver)
+		case *RetryPolicy:
+			a.retryPolicy = c.(*RetryPolicy)
+		case ProxyURL:
+			proxy = c.(ProxyURL)
+		case Timeout:
+			a.client.Timeout = time.Duration(c.(Timeout))
+		case http.RoundTripper:
+			// 替换底层的Transport，如用于录制与回放请求
+			a.client.Transport = c.(http.RoundTripper)
+		default:
+			return nil, errors.Errorf("unknown api config type(%s): %#v", reflect.TypeOf(c).String(), c)
+		}
+	}
+	// 代理需要在所有配置处理完后设置，以便检查是否同时传入了自定义的Transport
+	if proxy != "" {
+		err = a.applyProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.streamClient = &http.Client{
+		Transport: a.client.Transport,
+		Jar:       a.client.Jar,
+	}
+	return a, nil
 }
 
 // LoginInfo 登陆信息，登陆后可以获取到
@@ -144,15 +308,28 @@ type LoginInfo struct {
 	// loadTime   string // 登陆时间(10位时间戳字符串)
 }
 
-// 统一请求
+// 统一请求，失败时按照重试策略重试
 func (api *wechatwebAPI) request(req *http.Request) (resp *http.Response, err error) {
 	if req == nil {
 		return nil, errors.New("request is nil")
 	}
+	return api.requestWithRetry(api.client, req, path.Base(req.URL.Path))
+}
+
+// requestStream 同request，但不限制整体的超时时间，用于下载媒体文件等需要长时间读取响应体的请求
+func (api *wechatwebAPI) requestStream(req *http.Request) (resp *http.Response, err error) {
+	if req == nil {
+		return nil, errors.New("request is nil")
+	}
+	return api.requestWithRetry(api.streamClient, req, path.Base(req.URL.Path))
+}
+
+// doRequest 执行一次请求并更新cookie
+func (api *wechatwebAPI) doRequest(client *http.Client, req *http.Request) (resp *http.Response, err error) {
 	if api.userAgent != "" {
 		req.Header.Set("User-Agent", api.userAgent)
 	}
-	resp, err = api.client.Do(req)
+	resp, err = client.Do(req)
 	if err == nil {
 		api.refreshCookie(resp.Cookies())
 	}
@@ -0,0 +1,414 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CassetteRequest 录制的请求
+type CassetteRequest struct {
+	Method string
+	// URL 完整的请求地址（已脱敏）
+	URL string
+	// Path 请求的接口路径，回放时按此匹配
+	Path string
+	// Query 请求参数（已脱敏），回放时按归一化后的参数匹配
+	Query url.Values
+	// ContentType 请求体的类型
+	ContentType string `json:",omitempty"`
+	// Body 请求体（已脱敏），仅供查阅，回放时不参与匹配
+	Body []byte `json:",omitempty"`
+}
+
+// CassetteResponse 录制的响应
+type CassetteResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Interaction 一次请求与响应
+type Interaction struct {
+	Request  CassetteRequest
+	Response CassetteResponse
+}
+
+// Cassette 录制的会话
+type Cassette struct {
+	Interactions []Interaction
+}
+
+// LoadCassette 从文件中读取录制的会话
+func LoadCassette(path string) (cassette *Cassette, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("read cassette file error: " + err.Error())
+	}
+	cassette = &Cassette{}
+	err = json.Unmarshal(data, cassette)
+	if err != nil {
+		return nil, errors.New("Unmarshal cassette error: " + err.Error())
+	}
+	return cassette, nil
+}
+
+// Save 将录制的会话写入文件
+func (c *Cassette) Save(path string) (err error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.New("Marshal cassette error: " + err.Error())
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return errors.New("write cassette file error: " + err.Error())
+	}
+	return nil
+}
+
+// ignoredQueryKeys 归一化请求参数时忽略的参数
+// r、_、rr为tool.GetWxTimeStamp生成的时间戳，deviceid为每个api实例随机生成
+var ignoredQueryKeys = map[string]bool{
+	"r":        true,
+	"_":        true,
+	"rr":       true,
+	"deviceid": true,
+}
+
+// normalizeQuery 归一化请求参数，去掉时间戳等每次请求都会变化的参数后按key排序编码
+func normalizeQuery(query url.Values) string {
+	normalized := url.Values{}
+	for key, values := range query {
+		if !ignoredQueryKeys[key] {
+			normalized[key] = values
+		}
+	}
+	// url.Values.Encode会按key排序
+	return normalized.Encode()
+}
+
+// interactionKey 回放时匹配请求使用的key
+func interactionKey(method, path string, query url.Values) string {
+	return method + " " + path + "?" + normalizeQuery(query)
+}
+
+// secretQueryKeys 需要脱敏的请求参数，value为脱敏后占位符使用的名称
+var secretQueryKeys = map[string]string{
+	"pass_ticket":       "pass_ticket",
+	"skey":              "skey",
+	"sid":               "wxsid",
+	"uin":               "wxuin",
+	"fromuser":          "wxuin",
+	"ticket":            "ticket",
+	"webwx_data_ticket": "webwx_data_ticket",
+}
+
+// secretCookies 需要脱敏的cookie，value为脱敏后占位符使用的名称
+var secretCookies = map[string]string{
+	"wxuin":             "wxuin",
+	"wxsid":             "wxsid",
+	"webwxuvid":         "webwxuvid",
+	"webwx_data_ticket": "webwx_data_ticket",
+	"webwx_auth_ticket": "webwx_auth_ticket",
+}
+
+var (
+	// secretXMLRegexp webwxnewloginpage返回的xml中的登陆凭据
+	secretXMLRegexp = regexp.MustCompile(`<(skey|wxsid|wxuin|pass_ticket)>([^<]+)</`)
+	// secretJSONRegexp json中的登陆凭据
+	secretJSONRegexp = regexp.MustCompile(`"(S[Kk]ey|Sid|Uin)"\s*:\s*"([^"]+)"`)
+	// secretNameOfField xml与json字段对应的占位符名称
+	secretNameOfField = map[string]string{
+		"skey":        "skey",
+		"SKey":        "skey",
+		"Skey":        "skey",
+		"wxsid":       "wxsid",
+		"Sid":         "wxsid",
+		"wxuin":       "wxuin",
+		"Uin":         "wxuin",
+		"pass_ticket": "pass_ticket",
+	}
+)
+
+// minSecretLength 长度小于此值的凭据不做替换，防止误替换正常内容
+const minSecretLength = 6
+
+// redactor 收集会话中出现的登陆凭据并替换为占位符
+// 同一个凭据在整个会话中替换为同一个占位符，保证回放时客户端拿到的凭据与请求中的一致
+type redactor struct {
+	placeholders map[string]string // 凭据 -> 占位符
+	counter      map[string]int    // 占位符名称 -> 已使用次数
+	numberSeq    int64
+}
+
+func newRedactor() *redactor {
+	return &redactor{
+		placeholders: make(map[string]string),
+		counter:      make(map[string]int),
+		numberSeq:    1000000000,
+	}
+}
+
+// add 记录一个凭据
+func (r *redactor) add(name, secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	if _, ok := r.placeholders[secret]; ok {
+		return
+	}
+	if _, err := strconv.ParseInt(secret, 10, 64); err == nil {
+		// 纯数字的凭据（如uin）在json中以数字出现，占位符也必须是数字
+		r.numberSeq++
+		r.placeholders[secret] = strconv.FormatInt(r.numberSeq, 10)
+		return
+	}
+	r.counter[name]++
+	r.placeholders[secret] = fmt.Sprintf("redacted_%s_%d", name, r.counter[name])
+}
+
+// collect 从一次请求与响应中收集凭据
+func (r *redactor) collect(interaction *Interaction) {
+	for key, values := range interaction.Request.Query {
+		if name, ok := secretQueryKeys[key]; ok {
+			for _, value := range values {
+				r.add(name, value)
+			}
+		}
+	}
+	resp := &http.Response{Header: interaction.Response.Header}
+	for _, cookie := range resp.Cookies() {
+		if name, ok := secretCookies[cookie.Name]; ok {
+			r.add(name, cookie.Value)
+		}
+	}
+	for _, body := range [][]byte{interaction.Request.Body, interaction.Response.Body} {
+		for _, match := range secretXMLRegexp.FindAllSubmatch(body, -1) {
+			r.add(secretNameOfField[string(match[1])], string(match[2]))
+		}
+		for _, match := range secretJSONRegexp.FindAllSubmatch(body, -1) {
+			r.add(secretNameOfField[string(match[1])], string(match[2]))
+		}
+	}
+}
+
+// replacer 生成替换所有凭据的Replacer，同时替换原文与url编码后的形式
+func (r *redactor) replacer() *strings.Replacer {
+	secrets := make([]string, 0, len(r.placeholders))
+	for secret := range r.placeholders {
+		secrets = append(secrets, secret)
+	}
+	// 长的凭据先替换，防止凭据之间互相包含时替换不完整
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	var oldnew []string
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, r.placeholders[secret])
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			oldnew = append(oldnew, escaped, url.QueryEscape(r.placeholders[secret]))
+		}
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// redact 对整个会话脱敏
+func redact(interactions []Interaction) []Interaction {
+	r := newRedactor()
+	for i := range interactions {
+		r.collect(&interactions[i])
+	}
+	replacer := r.replacer()
+	replaceBytes := func(data []byte) []byte {
+		if data == nil {
+			return nil
+		}
+		return []byte(replacer.Replace(string(data)))
+	}
+	redacted := make([]Interaction, 0, len(interactions))
+	for _, interaction := range interactions {
+		query := url.Values{}
+		for key, values := range interaction.Request.Query {
+			for _, value := range values {
+				query.Add(key, replacer.Replace(value))
+			}
+		}
+		header := http.Header{}
+		for key, values := range interaction.Response.Header {
+			if key == "Content-Length" {
+				// 脱敏后内容长度可能改变，回放时根据Body重新计算
+				continue
+			}
+			for _, value := range values {
+				header.Add(key, replacer.Replace(value))
+			}
+		}
+		redacted = append(redacted, Interaction{
+			Request: CassetteRequest{
+				Method:      interaction.Request.Method,
+				URL:         replacer.Replace(interaction.Request.URL),
+				Path:        interaction.Request.Path,
+				Query:       query,
+				ContentType: interaction.Request.ContentType,
+				Body:        replaceBytes(interaction.Request.Body),
+			},
+			Response: CassetteResponse{
+				StatusCode: interaction.Response.StatusCode,
+				Header:     header,
+				Body:       replaceBytes(interaction.Response.Body),
+			},
+		})
+	}
+	return redacted
+}
+
+// cloneHeader 复制http.Header
+func cloneHeader(header http.Header) http.Header {
+	cloned := make(http.Header, len(header))
+	for key, values := range header {
+		cloned[key] = append([]string(nil), values...)
+	}
+	return cloned
+}
+
+// Recorder 录制请求与响应的http.RoundTripper
+// 可作为配置传入api.NewWechatwebAPI，录制完成后调用Save保存脱敏后的会话
+type Recorder struct {
+	transport    http.RoundTripper
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder 创建录制器
+// @param transport 实际发送请求的RoundTripper，为nil时使用http.DefaultTransport
+func NewRecorder(transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{
+		transport: transport,
+	}
+}
+
+// RoundTrip 发送请求并记录请求与响应
+func (r *Recorder) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	var reqBody []byte
+	if req.Body != nil {
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.New("read request body error: " + err.Error())
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err = r.transport.RoundTrip(req)
+	if err != nil {
+		return
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.New("read respond body error: " + err.Error())
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: CassetteRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			Path:        req.URL.Path,
+			Query:       req.URL.Query(),
+			ContentType: req.Header.Get("Content-Type"),
+			Body:        reqBody,
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode,
+			Header:     cloneHeader(resp.Header),
+			Body:       respBody,
+		},
+	})
+	return resp, nil
+}
+
+// Cassette 获取脱敏后的会话
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interactions := append([]Interaction(nil), r.interactions...)
+	return &Cassette{
+		Interactions: redact(interactions),
+	}
+}
+
+// Save 将脱敏后的会话写入文件
+func (r *Recorder) Save(path string) (err error) {
+	return r.Cassette().Save(path)
+}
+
+// Replayer 回放录制的会话的http.RoundTripper
+// 按请求方法、接口路径与归一化后的请求参数匹配，同一个请求多次出现时按录制的顺序依次返回
+type Replayer struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+// NewReplayer 创建回放器
+func NewReplayer(cassette *Cassette) *Replayer {
+	r := &Replayer{
+		interactions: make(map[string][]Interaction),
+	}
+	for _, interaction := range cassette.Interactions {
+		key := interactionKey(interaction.Request.Method, interaction.Request.Path, interaction.Request.Query)
+		r.interactions[key] = append(r.interactions[key], interaction)
+	}
+	return r
+}
+
+// RoundTrip 返回与请求匹配的录制的响应
+func (r *Replayer) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := interactionKey(req.Method, req.URL.Path, req.URL.Query())
+	r.mu.Lock()
+	queue := r.interactions[key]
+	if len(queue) == 0 {
+		r.mu.Unlock()
+		return nil, errors.Errorf("no recorded interaction for %s", key)
+	}
+	interaction := queue[0]
+	r.interactions[key] = queue[1:]
+	r.mu.Unlock()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cloneHeader(interaction.Response.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(interaction.Response.Body)),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining 尚未回放的请求数
+func (r *Replayer) Remaining() (count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, queue := range r.interactions {
+		count += len(queue)
+	}
+	return
+}
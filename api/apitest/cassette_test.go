@@ -0,0 +1,95 @@
+package apitest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/api/apitest"
+	"github.com/ikuiki/wwdk/datastruct"
+)
+
+// runSession 执行一次登陆、同步、发送消息的会话
+func runSession(t *testing.T, wxAPI api.WechatwebAPI, beforeLogin, beforeSync func()) {
+	uuid, _, err := wxAPI.JsLogin()
+	if err != nil {
+		t.Fatalf("JsLogin error: %v", err)
+	}
+	beforeLogin()
+	code, _, redirectURL, _, err := wxAPI.Login(uuid, "1")
+	if err != nil || code != "200" {
+		t.Fatalf("Login expect code 200, got %s(%v)", code, err)
+	}
+	_, err = wxAPI.WebwxNewLoginPage(redirectURL)
+	if err != nil {
+		t.Fatalf("WebwxNewLoginPage error: %v", err)
+	}
+	user, _, _, err := wxAPI.WebwxInit()
+	if err != nil || user.UserName != "@self" {
+		t.Fatalf("WebwxInit expect user @self, got %#v(%v)", user, err)
+	}
+	contactList, _, err := wxAPI.GetContact()
+	if err != nil || len(contactList) != 1 {
+		t.Fatalf("GetContact expect 1 contact, got %d(%v)", len(contactList), err)
+	}
+	beforeSync()
+	_, selector, _, err := wxAPI.SyncCheck()
+	if err != nil || selector != "2" {
+		t.Fatalf("SyncCheck expect selector 2, got %s(%v)", selector, err)
+	}
+	_, _, addMessages, _, err := wxAPI.WebwxSync()
+	if err != nil || len(addMessages) != 1 || addMessages[0].Content != "hello" {
+		t.Fatalf("WebwxSync expect message hello, got %#v(%v)", addMessages, err)
+	}
+	msgID, _, _, err := wxAPI.SendTextMessage(user.UserName, "@friend", "hi")
+	if err != nil || msgID == "" {
+		t.Fatalf("SendTextMessage error: %v", err)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wwdk-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cassettePath := filepath.Join(dir, "session.json")
+
+	// 录制
+	srv := apitest.NewServer()
+	srv.AddContact(datastruct.Contact{UserName: "@friend", NickName: "friend"})
+	recorder := apitest.NewRecorder(nil)
+	runSession(t, api.MustNewWechatwebAPI(srv.EndpointResolver(), recorder), srv.Confirm, func() {
+		srv.InjectMessage(datastruct.Message{FromUserName: "@friend", MsgType: datastruct.TextMsg, Content: "hello"})
+	})
+	srv.Close()
+	err = recorder.Save(cassettePath)
+	if err != nil {
+		t.Fatalf("Save cassette error: %v", err)
+	}
+
+	// 录制的文件中不能包含登陆凭据
+	data, err := ioutil.ReadFile(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"2100000000", "QQtestsid0000000", "crypt_test_skey", "test_pass_ticket", "test_data_ticket", "test_auth_ticket", "test_ticket"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains secret %s", secret)
+		}
+	}
+
+	// 回放，服务器已经关闭
+	cassette, err := apitest.LoadCassette(cassettePath)
+	if err != nil {
+		t.Fatalf("LoadCassette error: %v", err)
+	}
+	replayer := apitest.NewReplayer(cassette)
+	runSession(t, api.MustNewWechatwebAPI(srv.EndpointResolver(), replayer), func() {}, func() {})
+	if remaining := replayer.Remaining(); remaining != 0 {
+		t.Errorf("expect all interactions replayed, %d remaining", remaining)
+	}
+}
@@ -0,0 +1,500 @@
+package apitest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/pkg/errors"
+)
+
+// FakeAPI api.WechatwebAPI的内存实现
+// 不发起任何网络请求，调用Login时直接视为已确认登陆
+// 可作为配置传入wwdk.NewWechatWeb，用于在单元测试中驱动WechatWeb
+// 联系人、消息等账号状态以及注入事件、检查请求的方法由内嵌的State提供，与Server一致
+type FakeAPI struct {
+	*State
+	notifyCh chan<- bool
+}
+
+// fakePushUUID 推送登陆返回的uuid
+const fakePushUUID = "fake_push_uuid"
+
+// NewFakeAPI 创建内存实现的WechatwebAPI
+func NewFakeAPI() *FakeAPI {
+	return &FakeAPI{
+		State: newState(),
+	}
+}
+
+// 确保FakeAPI实现了api.WechatwebAPI
+var _ api.WechatwebAPI = (*FakeAPI)(nil)
+
+// checkLogin 检查是否已退出登陆，调用时必须持有锁
+func (f *FakeAPI) checkLogin(endpoint string) error {
+	if f.loggedOut {
+		return &api.APIError{Endpoint: endpoint, Ret: api.RetLoginElsewhere, Err: api.ErrLogout}
+	}
+	return nil
+}
+
+// loginCodes 登陆状态对应的login接口返回码
+var loginCodes = map[LoginState]string{
+	LoginStateWaitForScan: "408",
+	LoginStateScaned:      "201",
+	LoginStateConfirmed:   "200",
+	LoginStateExpired:     "400",
+}
+
+// JsLogin 获取uuid
+func (f *FakeAPI) JsLogin() (uuid string, body []byte, err error) {
+	return "fake_uuid", nil, nil
+}
+
+// PushLogin 推送登陆，登陆过才能推送
+func (f *FakeAPI) PushLogin() (uuid string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.logined {
+		return "", nil, &api.APIError{Endpoint: "webwxpushloginurl", Err: api.ErrNoUin}
+	}
+	return fakePushUUID, nil, nil
+}
+
+// Login 等待用户扫码登陆，直接返回已确认登陆
+// 推送登陆的uuid按照PushLoginRefused与PushLoginIgnored返回二维码失效或等待登陆
+func (f *FakeAPI) Login(uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if uuid == fakePushUUID {
+		if state := f.pushLoginState(LoginStateConfirmed); state != LoginStateConfirmed {
+			return loginCodes[state], "", "", nil, nil
+		}
+	}
+	f.confirmLogin()
+	return "200", "", "https://wx.qq.com/cgi-bin/mmwebwx-bin/webwxnewloginpage?ticket=fake_ticket&uuid=" + uuid + "&lang=zh_CN&scan=1", nil, nil
+}
+
+// WebwxNewLoginPage 获取登陆凭据
+func (f *FakeAPI) WebwxNewLoginPage(redirectURL string) (body []byte, err error) {
+	return nil, nil
+}
+
+// WebwxInit 初始化微信，返回当前用户与群聊（不包含群成员）
+func (f *FakeAPI) WebwxInit() (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxinit"); err != nil {
+		return
+	}
+	u, contactList := f.webwxInit()
+	return &u, contactList, nil, nil
+}
+
+// Logout 退出登录
+func (f *FakeAPI) Logout() (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logout()
+	return nil, nil
+}
+
+// GetContact 获取联系人，群聊不包含群成员
+func (f *FakeAPI) GetContact() (contactList []datastruct.Contact, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxgetcontact"); err != nil {
+		return
+	}
+	return f.contactList(), nil, nil
+}
+
+// GetContactPage 获取一页联系人，每页的大小由ContactPageSize决定
+func (f *FakeAPI) GetContactPage(seq int64) (contactList []datastruct.Contact, nextSeq int64, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxgetcontact"); err != nil {
+		return
+	}
+	contactList, nextSeq = f.contactPage(seq)
+	return
+}
+
+// BatchGetContact 获取联系人的完整信息
+func (f *FakeAPI) BatchGetContact(contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxbatchgetcontact"); err != nil {
+		return
+	}
+	contactList, ok := f.batchGetContact(contactItemList)
+	if !ok {
+		return nil, nil, &api.APIError{Endpoint: "webwxbatchgetcontact", Ret: 1, ErrMsg: "system error"}
+	}
+	return
+}
+
+// ModifyUserRemakName 修改联系人备注
+func (f *FakeAPI) ModifyUserRemakName(userName, remarkName string) (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxoplog"); err != nil {
+		return
+	}
+	if !f.modifyRemarkName(userName, remarkName) {
+		return nil, errors.Errorf("contact %s not found", userName)
+	}
+	return
+}
+
+// VerifyUser 好友验证
+func (f *FakeAPI) VerifyUser(opcode datastruct.VerifyUserOpcode, userName, ticket string) (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxverifyuser"); err != nil {
+		return
+	}
+	f.verifyUser(userName)
+	return
+}
+
+// ModifyChatRoomTopic 修改聊天室标题
+func (f *FakeAPI) ModifyChatRoomTopic(userName, newTopic string) (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxupdatechatroom"); err != nil {
+		return
+	}
+	f.modifyChatRoomTopic(userName, newTopic)
+	return
+}
+
+// CreateChatRoom 创建群聊
+func (f *FakeAPI) CreateChatRoom(topic string, memberUserNames []string) (chatRoomName string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxcreatechatroom"); err != nil {
+		return
+	}
+	return f.createChatRoom(topic, memberUserNames).UserName, nil, nil
+}
+
+// AddChatRoomMember 添加群成员
+func (f *FakeAPI) AddChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxupdatechatroom"); err != nil {
+		return
+	}
+	memberList, ok := f.addChatRoomMember(chatRoomName, userNames)
+	if !ok {
+		return nil, nil, errors.Errorf("chatroom %s not found", chatRoomName)
+	}
+	return
+}
+
+// InviteChatRoomMember 邀请群成员，被邀请的联系人需要接受邀请后才会成为群成员
+func (f *FakeAPI) InviteChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxupdatechatroom"); err != nil {
+		return
+	}
+	memberList, ok := f.inviteChatRoomMember(chatRoomName, userNames)
+	if !ok {
+		return nil, nil, errors.Errorf("chatroom %s not found", chatRoomName)
+	}
+	return
+}
+
+// DelChatRoomMember 移除群成员
+func (f *FakeAPI) DelChatRoomMember(chatRoomName string, userNames []string) (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxupdatechatroom"); err != nil {
+		return
+	}
+	if !f.delChatRoomMember(chatRoomName, userNames) {
+		return nil, errors.Errorf("chatroom %s not found", chatRoomName)
+	}
+	return
+}
+
+// SyncCheck 检查同步，没有待同步的内容时最多等待PollTimeout
+func (f *FakeAPI) SyncCheck() (retCode, selector string, body []byte, err error) {
+	return f.SyncCheckContext(context.Background())
+}
+
+// SyncCheckContext 同SyncCheck，等待期间ctx取消时返回ctx的错误
+func (f *FakeAPI) SyncCheckContext(ctx context.Context) (retCode, selector string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err = f.wait(ctx, func() bool {
+		return f.loggedOut || f.hasPending()
+	}); err != nil {
+		return
+	}
+	switch {
+	case f.loggedOut:
+		return "1101", "0", nil, &api.APIError{Endpoint: "synccheck", Ret: api.RetLoginElsewhere, Err: api.ErrLogout}
+	case f.hasPending():
+		return "0", "2", nil, nil
+	default:
+		return "0", "0", nil, nil
+	}
+}
+
+// WebwxSync 同步消息，返回并清空待同步的内容
+func (f *FakeAPI) WebwxSync() (modContacts []datastruct.Contact,
+	delContacts []datastruct.WebwxSyncRespondDelContactListItem,
+	addMessages []datastruct.Message,
+	body []byte, err error) {
+	result, body, err := f.WebwxSyncDetail()
+	return result.ModContacts, result.DelContacts, result.AddMessages, body, err
+}
+
+// WebwxSyncDetail 同步消息，返回并清空待同步的内容，包括群成员与当前用户资料的变更
+// 与api一样，消息超过SyncBatchSize时会继续同步，直到全部获取完毕
+func (f *FakeAPI) WebwxSyncDetail() (result api.SyncResult, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxsync"); err != nil {
+		return
+	}
+	for more := true; more; {
+		var part api.SyncResult
+		part, more = f.sync()
+		result.ModContacts = append(result.ModContacts, part.ModContacts...)
+		result.DelContacts = append(result.DelContacts, part.DelContacts...)
+		result.AddMessages = append(result.AddMessages, part.AddMessages...)
+		result.ModChatRoomMembers = append(result.ModChatRoomMembers, part.ModChatRoomMembers...)
+		if part.Profile != nil {
+			result.Profile = part.Profile
+		}
+	}
+	return
+}
+
+// StatusNotify 消息已读通知
+func (f *FakeAPI) StatusNotify(fromUserName, toUserName string, code int64) (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err = f.checkLogin("webwxstatusnotify")
+	return
+}
+
+// send 记录发送的消息，调用时必须持有锁
+func (f *FakeAPI) send(apiPath string, msg datastruct.SendMessage) (MsgID, LocalID string, body []byte, err error) {
+	if err = f.checkLogin(path.Base(apiPath)); err != nil {
+		return
+	}
+	MsgID = f.State.send(apiPath, msg)
+	return MsgID, MsgID, nil, nil
+}
+
+// SendTextMessage 发送消息
+func (f *FakeAPI) SendTextMessage(fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.send("/cgi-bin/mmwebwx-bin/webwxsendmsg", datastruct.SendMessage{
+		Type:         datastruct.TextMsg,
+		Content:      content,
+		FromUserName: fromUserName,
+		ToUserName:   toUserName,
+	})
+}
+
+// SendRevokeMessage 撤回消息
+func (f *FakeAPI) SendRevokeMessage(toUserName, svrMsgID, clientMsgID string) (body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxrevokemsg"); err != nil {
+		return
+	}
+	f.revoke(datastruct.RevokeMessageRequest{
+		ClientMsgID: clientMsgID,
+		SvrMsgID:    svrMsgID,
+		ToUserName:  toUserName,
+	})
+	return
+}
+
+// UploadMedia 上传文件，上传后可通过Media获取文件内容
+func (f *FakeAPI) UploadMedia(fromUserName, toUserName, fileName string, file io.ReadSeeker) (mediaID string, body []byte, err error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		err = errors.New("read file error: " + err.Error())
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err = f.checkLogin("webwxuploadmedia"); err != nil {
+		return
+	}
+	mediaID = f.upload(data, "")
+	return
+}
+
+// SendImageMessage 发送图片消息
+func (f *FakeAPI) SendImageMessage(fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.send("/cgi-bin/mmwebwx-bin/webwxsendmsgimg", datastruct.SendMessage{
+		Type:         datastruct.ImageMsg,
+		MediaID:      mediaID,
+		FromUserName: fromUserName,
+		ToUserName:   toUserName,
+	})
+}
+
+// SendVideoMessage 发送视频消息
+func (f *FakeAPI) SendVideoMessage(fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.send("/cgi-bin/mmwebwx-bin/webwxsendvideomsg", datastruct.SendMessage{
+		Type:         datastruct.LittleVideoMsg,
+		MediaID:      mediaID,
+		FromUserName: fromUserName,
+		ToUserName:   toUserName,
+	})
+}
+
+// SendFileMessage 发送文件消息，Content中记录文件名
+func (f *FakeAPI) SendFileMessage(fromUserName, toUserName, mediaID, fileName string, fileSize int64) (MsgID, LocalID string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.send("/cgi-bin/mmwebwx-bin/webwxsendappmsg", datastruct.SendMessage{
+		Type:         datastruct.MessageType(datastruct.ReciveFileAppmsg),
+		Content:      fileName,
+		MediaID:      mediaID,
+		FromUserName: fromUserName,
+		ToUserName:   toUserName,
+	})
+}
+
+// SendEmoticonMessage 发送动图消息
+func (f *FakeAPI) SendEmoticonMessage(fromUserName, toUserName, mediaID, emoticonMd5 string) (MsgID, LocalID string, body []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.send("/cgi-bin/mmwebwx-bin/webwxsendemoticon", datastruct.SendMessage{
+		Type:         datastruct.AnimationEmotionsMsg,
+		EmojiFlag:    2,
+		EMoticonMd5:  emoticonMd5,
+		MediaID:      mediaID,
+		FromUserName: fromUserName,
+		ToUserName:   toUserName,
+	})
+}
+
+// openMedia 打开媒体文件流
+func (f *FakeAPI) openMedia(key string) (stream *api.MediaStream, err error) {
+	item, ok := f.getMedia(key)
+	if !ok {
+		return nil, errors.Errorf("media %s not found", key)
+	}
+	return &api.MediaStream{
+		ReadCloser:    ioutil.NopCloser(bytes.NewReader(item.data)),
+		ContentLength: int64(len(item.data)),
+		ContentType:   item.contentType,
+	}, nil
+}
+
+// readMedia 读取媒体文件
+func (f *FakeAPI) readMedia(key string) (data []byte, err error) {
+	item, ok := f.getMedia(key)
+	if !ok {
+		return nil, errors.Errorf("media %s not found", key)
+	}
+	return item.data, nil
+}
+
+// headImgKey 从头像地址中取出username作为媒体文件的key
+func headImgKey(headImgURL string) string {
+	u, err := url.Parse(headImgURL)
+	if err != nil {
+		return headImgURL
+	}
+	return u.Query().Get("username")
+}
+
+// SaveMessageImage 下载图片消息
+func (f *FakeAPI) SaveMessageImage(msgID string) (imgData []byte, err error) {
+	return f.readMedia(msgID)
+}
+
+// SaveMessageVoice 下载音频消息
+func (f *FakeAPI) SaveMessageVoice(msgID string) (voiceData []byte, err error) {
+	return f.readMedia(msgID)
+}
+
+// SaveMessageVideo 下载视频消息
+func (f *FakeAPI) SaveMessageVideo(msgID string) (videoData []byte, err error) {
+	return f.readMedia(msgID)
+}
+
+// SaveMessageFile 下载文件消息的附件
+func (f *FakeAPI) SaveMessageFile(mediaID, fileName, fromUserName string) (fileData []byte, err error) {
+	return f.readMedia(mediaID)
+}
+
+// SaveContactImg 保存联系人头像
+func (f *FakeAPI) SaveContactImg(headImgURL string) (imgData []byte, err error) {
+	return f.readMedia(headImgKey(headImgURL))
+}
+
+// SaveMemberImg 保存群成员的头像
+func (f *FakeAPI) SaveMemberImg(userName, chatroomID string) (imgData []byte, err error) {
+	return f.readMedia(userName)
+}
+
+// StreamMessageImage 以流的方式下载图片消息
+func (f *FakeAPI) StreamMessageImage(msgID string) (stream *api.MediaStream, err error) {
+	return f.openMedia(msgID)
+}
+
+// StreamMessageVoice 以流的方式下载音频消息
+func (f *FakeAPI) StreamMessageVoice(msgID string) (stream *api.MediaStream, err error) {
+	return f.openMedia(msgID)
+}
+
+// StreamMessageVideo 以流的方式下载视频消息
+func (f *FakeAPI) StreamMessageVideo(msgID string) (stream *api.MediaStream, err error) {
+	return f.openMedia(msgID)
+}
+
+// StreamMessageFile 以流的方式下载文件消息的附件
+func (f *FakeAPI) StreamMessageFile(mediaID, fileName, fromUserName string) (stream *api.MediaStream, err error) {
+	return f.openMedia(mediaID)
+}
+
+// StreamContactImg 以流的方式下载联系人头像
+func (f *FakeAPI) StreamContactImg(headImgURL string) (stream *api.MediaStream, err error) {
+	return f.openMedia(headImgKey(headImgURL))
+}
+
+// StreamMemberImg 以流的方式下载群成员的头像
+func (f *FakeAPI) StreamMemberImg(userName, chatroomID string) (stream *api.MediaStream, err error) {
+	return f.openMedia(userName)
+}
+
+// SetLoginModifyNotifyChan 设置登陆信息变更通知管道
+func (f *FakeAPI) SetLoginModifyNotifyChan(notifyChan chan<- bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notifyCh = notifyChan
+}
+
+// Marshal 序列化，FakeAPI没有需要保存的登陆凭据
+func (f *FakeAPI) Marshal() (data []byte, err error) {
+	return []byte("{}"), nil
+}
+
+// Unmarshal 反序列化，FakeAPI没有需要恢复的登陆凭据
+func (f *FakeAPI) Unmarshal(data []byte) (err error) {
+	return nil
+}
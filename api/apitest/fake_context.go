@@ -0,0 +1,315 @@
+package apitest
+
+// 此文件中为FakeAPI的Context方法，ctx已取消时直接返回ctx的错误，否则调用对应的方法
+
+import (
+	"context"
+	"io"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/datastruct"
+)
+
+// JsLoginContext 同JsLogin
+func (f *FakeAPI) JsLoginContext(ctx context.Context) (uuid string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.JsLogin()
+}
+
+// LoginContext 同Login
+func (f *FakeAPI) LoginContext(ctx context.Context, uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.Login(uuid, tip)
+}
+
+// PushLoginContext 同PushLogin
+func (f *FakeAPI) PushLoginContext(ctx context.Context) (uuid string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.PushLogin()
+}
+
+// WebwxNewLoginPageContext 同WebwxNewLoginPage
+func (f *FakeAPI) WebwxNewLoginPageContext(ctx context.Context, redirectURL string) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.WebwxNewLoginPage(redirectURL)
+}
+
+// WebwxInitContext 同WebwxInit
+func (f *FakeAPI) WebwxInitContext(ctx context.Context) (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.WebwxInit()
+}
+
+// LogoutContext 同Logout
+func (f *FakeAPI) LogoutContext(ctx context.Context) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.Logout()
+}
+
+// GetContactContext 同GetContact
+func (f *FakeAPI) GetContactContext(ctx context.Context) (contactList []datastruct.Contact, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.GetContact()
+}
+
+// GetContactPageContext 同GetContactPage
+func (f *FakeAPI) GetContactPageContext(ctx context.Context, seq int64) (contactList []datastruct.Contact, nextSeq int64, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.GetContactPage(seq)
+}
+
+// BatchGetContactContext 同BatchGetContact
+func (f *FakeAPI) BatchGetContactContext(ctx context.Context, contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.BatchGetContact(contactItemList)
+}
+
+// ModifyUserRemakNameContext 同ModifyUserRemakName
+func (f *FakeAPI) ModifyUserRemakNameContext(ctx context.Context, userName, remarkName string) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.ModifyUserRemakName(userName, remarkName)
+}
+
+// VerifyUserContext 同VerifyUser
+func (f *FakeAPI) VerifyUserContext(ctx context.Context, opcode datastruct.VerifyUserOpcode, userName, ticket string) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.VerifyUser(opcode, userName, ticket)
+}
+
+// ModifyChatRoomTopicContext 同ModifyChatRoomTopic
+func (f *FakeAPI) ModifyChatRoomTopicContext(ctx context.Context, userName, newTopic string) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.ModifyChatRoomTopic(userName, newTopic)
+}
+
+// CreateChatRoomContext 同CreateChatRoom
+func (f *FakeAPI) CreateChatRoomContext(ctx context.Context, topic string, memberUserNames []string) (chatRoomName string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.CreateChatRoom(topic, memberUserNames)
+}
+
+// AddChatRoomMemberContext 同AddChatRoomMember
+func (f *FakeAPI) AddChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.AddChatRoomMember(chatRoomName, userNames)
+}
+
+// InviteChatRoomMemberContext 同InviteChatRoomMember
+func (f *FakeAPI) InviteChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.InviteChatRoomMember(chatRoomName, userNames)
+}
+
+// DelChatRoomMemberContext 同DelChatRoomMember
+func (f *FakeAPI) DelChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.DelChatRoomMember(chatRoomName, userNames)
+}
+
+// WebwxSyncContext 同WebwxSync
+func (f *FakeAPI) WebwxSyncContext(ctx context.Context) (modContacts []datastruct.Contact, delContacts []datastruct.WebwxSyncRespondDelContactListItem, addMessages []datastruct.Message, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.WebwxSync()
+}
+
+// WebwxSyncDetailContext 同WebwxSyncDetail
+func (f *FakeAPI) WebwxSyncDetailContext(ctx context.Context) (result api.SyncResult, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.WebwxSyncDetail()
+}
+
+// StatusNotifyContext 同StatusNotify
+func (f *FakeAPI) StatusNotifyContext(ctx context.Context, fromUserName, toUserName string, code int64) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StatusNotify(fromUserName, toUserName, code)
+}
+
+// SendTextMessageContext 同SendTextMessage
+func (f *FakeAPI) SendTextMessageContext(ctx context.Context, fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SendTextMessage(fromUserName, toUserName, content)
+}
+
+// SendRevokeMessageContext 同SendRevokeMessage
+func (f *FakeAPI) SendRevokeMessageContext(ctx context.Context, toUserName, svrMsgID, clientMsgID string) (body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SendRevokeMessage(toUserName, svrMsgID, clientMsgID)
+}
+
+// UploadMediaContext 同UploadMedia
+func (f *FakeAPI) UploadMediaContext(ctx context.Context, fromUserName, toUserName, fileName string, file io.ReadSeeker) (mediaID string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.UploadMedia(fromUserName, toUserName, fileName, file)
+}
+
+// SendImageMessageContext 同SendImageMessage
+func (f *FakeAPI) SendImageMessageContext(ctx context.Context, fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SendImageMessage(fromUserName, toUserName, mediaID)
+}
+
+// SendVideoMessageContext 同SendVideoMessage
+func (f *FakeAPI) SendVideoMessageContext(ctx context.Context, fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SendVideoMessage(fromUserName, toUserName, mediaID)
+}
+
+// SendFileMessageContext 同SendFileMessage
+func (f *FakeAPI) SendFileMessageContext(ctx context.Context, fromUserName, toUserName, mediaID, fileName string, fileSize int64) (MsgID, LocalID string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SendFileMessage(fromUserName, toUserName, mediaID, fileName, fileSize)
+}
+
+// SendEmoticonMessageContext 同SendEmoticonMessage
+func (f *FakeAPI) SendEmoticonMessageContext(ctx context.Context, fromUserName, toUserName, mediaID, emoticonMd5 string) (MsgID, LocalID string, body []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SendEmoticonMessage(fromUserName, toUserName, mediaID, emoticonMd5)
+}
+
+// SaveMessageImageContext 同SaveMessageImage
+func (f *FakeAPI) SaveMessageImageContext(ctx context.Context, msgID string) (imgData []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SaveMessageImage(msgID)
+}
+
+// SaveMessageVoiceContext 同SaveMessageVoice
+func (f *FakeAPI) SaveMessageVoiceContext(ctx context.Context, msgID string) (voiceData []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SaveMessageVoice(msgID)
+}
+
+// SaveMessageVideoContext 同SaveMessageVideo
+func (f *FakeAPI) SaveMessageVideoContext(ctx context.Context, msgID string) (videoData []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SaveMessageVideo(msgID)
+}
+
+// SaveMessageFileContext 同SaveMessageFile
+func (f *FakeAPI) SaveMessageFileContext(ctx context.Context, mediaID, fileName, fromUserName string) (fileData []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SaveMessageFile(mediaID, fileName, fromUserName)
+}
+
+// SaveContactImgContext 同SaveContactImg
+func (f *FakeAPI) SaveContactImgContext(ctx context.Context, headImgURL string) (imgData []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SaveContactImg(headImgURL)
+}
+
+// SaveMemberImgContext 同SaveMemberImg
+func (f *FakeAPI) SaveMemberImgContext(ctx context.Context, userName, chatroomID string) (imgData []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.SaveMemberImg(userName, chatroomID)
+}
+
+// StreamMessageImageContext 同StreamMessageImage
+func (f *FakeAPI) StreamMessageImageContext(ctx context.Context, msgID string) (stream *api.MediaStream, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StreamMessageImage(msgID)
+}
+
+// StreamMessageVoiceContext 同StreamMessageVoice
+func (f *FakeAPI) StreamMessageVoiceContext(ctx context.Context, msgID string) (stream *api.MediaStream, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StreamMessageVoice(msgID)
+}
+
+// StreamMessageVideoContext 同StreamMessageVideo
+func (f *FakeAPI) StreamMessageVideoContext(ctx context.Context, msgID string) (stream *api.MediaStream, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StreamMessageVideo(msgID)
+}
+
+// StreamMessageFileContext 同StreamMessageFile
+func (f *FakeAPI) StreamMessageFileContext(ctx context.Context, mediaID, fileName, fromUserName string) (stream *api.MediaStream, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StreamMessageFile(mediaID, fileName, fromUserName)
+}
+
+// StreamContactImgContext 同StreamContactImg
+func (f *FakeAPI) StreamContactImgContext(ctx context.Context, headImgURL string) (stream *api.MediaStream, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StreamContactImg(headImgURL)
+}
+
+// StreamMemberImgContext 同StreamMemberImg
+func (f *FakeAPI) StreamMemberImgContext(ctx context.Context, userName, chatroomID string) (stream *api.MediaStream, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return f.StreamMemberImg(userName, chatroomID)
+}
@@ -0,0 +1,563 @@
+package apitest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/ikuiki/wwdk/tool"
+)
+
+// serveHTTP 根据请求路径分发请求
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requestPaths = append(s.requestPaths, r.URL.Path)
+	s.mu.Unlock()
+	switch strings.TrimPrefix(r.URL.Path, "/cgi-bin/mmwebwx-bin/") {
+	case "/jslogin":
+		s.handleJsLogin(w, r)
+	case "login":
+		s.handleLogin(w, r)
+	case "webwxpushloginurl":
+		s.handlePushLogin(w, r)
+	case "webwxnewloginpage":
+		s.handleNewLoginPage(w, r)
+	case "webwxinit":
+		s.handleInit(w, r)
+	case "webwxlogout":
+		s.handleLogout(w, r)
+	case "webwxgetcontact":
+		s.handleGetContact(w, r)
+	case "webwxbatchgetcontact":
+		s.handleBatchGetContact(w, r)
+	case "synccheck":
+		s.handleSyncCheck(w, r)
+	case "webwxsync":
+		s.handleSync(w, r)
+	case "webwxstatusnotify":
+		s.handleBaseRequestOnly(w, r)
+	case "webwxoplog":
+		s.handleOpLog(w, r)
+	case "webwxverifyuser":
+		s.handleVerifyUser(w, r)
+	case "webwxupdatechatroom":
+		s.handleUpdateChatRoom(w, r)
+	case "webwxcreatechatroom":
+		s.handleCreateChatRoom(w, r)
+	case "webwxsendmsg", "webwxsendmsgimg", "webwxsendvideomsg", "webwxsendappmsg", "webwxsendemoticon":
+		s.handleSendMsg(w, r)
+	case "webwxrevokemsg":
+		s.handleRevokeMsg(w, r)
+	case "webwxuploadmedia":
+		s.handleUploadMedia(w, r)
+	case "webwxgetmsgimg", "webwxgetvoice":
+		s.handleMedia(w, r, r.URL.Query().Get("MsgID"))
+	case "webwxgetvideo":
+		s.handleMedia(w, r, r.URL.Query().Get("msgid"))
+	case "webwxgetmedia":
+		s.handleMedia(w, r, r.URL.Query().Get("mediaid"))
+	case "webwxgeticon", "webwxgetheadimg":
+		s.handleMedia(w, r, r.URL.Query().Get("username"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON 输出json
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "text/plain")
+	json.NewEncoder(w).Encode(v)
+}
+
+// baseResponse 生成BaseResponse
+func baseResponse(ret int64) *datastruct.BaseResponse {
+	return &datastruct.BaseResponse{Ret: ret}
+}
+
+// decodeBody 解析json请求体，同时返回其中的BaseRequest校验结果
+// @return ret 校验BaseRequest的结果，0为通过
+func (s *Server) decodeBody(r *http.Request, v interface{}) (ret int64, err error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return 0, err
+	}
+	if v != nil {
+		err = json.Unmarshal(data, v)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var base struct {
+		BaseRequest *datastruct.BaseRequest `json:"BaseRequest"`
+	}
+	json.Unmarshal(data, &base)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkBaseRequest(base.BaseRequest), nil
+}
+
+// checkBaseRequest 校验BaseRequest，调用时必须持有锁
+func (s *Server) checkBaseRequest(baseRequest *datastruct.BaseRequest) (ret int64) {
+	if s.loggedOut {
+		return api.RetLoginElsewhere
+	}
+	if baseRequest == nil || baseRequest.Sid != s.sid || baseRequest.Skey != s.skey || baseRequest.Uin != s.uin {
+		return api.RetSessionInvalid
+	}
+	return 0
+}
+
+// checkCookie 校验cookie中的登陆凭据，调用时必须持有锁
+func (s *Server) checkCookie(r *http.Request) (ret int64) {
+	if s.loggedOut {
+		return api.RetLoginElsewhere
+	}
+	c, err := r.Cookie("wxsid")
+	if err != nil || c.Value != s.sid {
+		return api.RetSessionInvalid
+	}
+	return 0
+}
+
+func (s *Server) handleJsLogin(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uuid = "uuid_" + tool.GetRandomStringFromNum(8)
+	s.loginState = LoginStateWaitForScan
+	if s.autoConfirm {
+		s.loginState = LoginStateConfirmed
+		s.confirmLogin()
+	}
+	s.reportedLoginState = LoginStateWaitForScan
+	fmt.Fprintf(w, `window.QRLogin.code = 200; window.QRLogin.uuid = "%s";`, s.uuid)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Query().Get("uuid") != s.uuid {
+		fmt.Fprint(w, `window.code=400;`)
+		return
+	}
+	changed, err := s.wait(r.Context(), func() bool {
+		return s.loginState != s.reportedLoginState
+	})
+	if err != nil || !changed {
+		fmt.Fprint(w, `window.code=408;`)
+		return
+	}
+	s.reportedLoginState = s.loginState
+	switch s.loginState {
+	case LoginStateScaned:
+		fmt.Fprintf(w, `window.code=201;window.userAvatar = '%s';`, s.avatar)
+	case LoginStateConfirmed:
+		fmt.Fprintf(w, `window.code=200;`+"\n"+`window.redirect_uri="%s/cgi-bin/mmwebwx-bin/webwxnewloginpage?ticket=test_ticket&uuid=%s&lang=zh_CN&scan=1";`,
+			s.server.URL, s.uuid)
+	case LoginStateExpired:
+		fmt.Fprint(w, `window.code=400;`)
+	default:
+		fmt.Fprint(w, `window.code=408;`)
+	}
+}
+
+func (s *Server) handlePushLogin(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.logined || r.URL.Query().Get("uin") != s.uin {
+		writeJSON(w, datastruct.PushLoginRespond{Ret: "1", Msg: "uin not match"})
+		return
+	}
+	// 推送后手机上直接显示确认登陆，相当于已扫码
+	s.uuid = "uuid_" + tool.GetRandomStringFromNum(8)
+	accepted := LoginStateScaned
+	if s.autoConfirm {
+		accepted = LoginStateConfirmed
+	}
+	s.loginState = s.pushLoginState(accepted)
+	if s.loginState == LoginStateConfirmed {
+		s.confirmLogin()
+	}
+	s.reportedLoginState = LoginStateWaitForScan
+	writeJSON(w, datastruct.PushLoginRespond{Ret: "0", Msg: "all ok", UUID: s.uuid})
+}
+
+func (s *Server) handleNewLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, value := range map[string]string{
+		"wxuin":             s.uin,
+		"wxsid":             s.sid,
+		"webwxuvid":         "test_uvid",
+		"webwx_data_ticket": s.dataTicket,
+		"webwx_auth_ticket": s.authTicket,
+	} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: value, Path: "/"})
+	}
+	resp := struct {
+		XMLName xml.Name `xml:"error"`
+		datastruct.GetCookieRespond
+	}{
+		GetCookieRespond: datastruct.GetCookieRespond{
+			Skey:        s.skey,
+			Wxsid:       s.sid,
+			Wxuin:       s.uin,
+			PassTicket:  s.passTicket,
+			Isgrayscale: 1,
+		},
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleInit(w http.ResponseWriter, r *http.Request) {
+	ret, err := s.decodeBody(r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret != 0 {
+		writeJSON(w, datastruct.WxInitRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	user, contactList := s.webwxInit()
+	writeJSON(w, datastruct.WxInitRespond{
+		BaseResponse: baseResponse(0),
+		ContactList:  contactList,
+		Count:        int64(len(contactList)),
+		SKey:         s.skey,
+		SyncKey:      s.syncKey(),
+		User:         &user,
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logout()
+}
+
+func (s *Server) handleGetContact(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret := s.checkCookie(r); ret != 0 {
+		writeJSON(w, datastruct.GetContactRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	seq, _ := strconv.ParseInt(r.URL.Query().Get("seq"), 10, 64)
+	contactList, nextSeq := s.contactPage(seq)
+	writeJSON(w, datastruct.GetContactRespond{
+		BaseResponse: baseResponse(0),
+		MemberCount:  int64(len(contactList)),
+		MemberList:   contactList,
+		Seq:          nextSeq,
+	})
+}
+
+func (s *Server) handleBatchGetContact(w http.ResponseWriter, r *http.Request) {
+	var req datastruct.BatchGetContactRequest
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret != 0 {
+		writeJSON(w, datastruct.BatchGetContactResponse{BaseResponse: baseResponse(ret)})
+		return
+	}
+	contactList, ok := s.batchGetContact(req.List)
+	if !ok {
+		writeJSON(w, datastruct.BatchGetContactResponse{BaseResponse: &datastruct.BaseResponse{Ret: 1, ErrMsg: "system error"}})
+		return
+	}
+	writeJSON(w, datastruct.BatchGetContactResponse{
+		BaseResponse: baseResponse(0),
+		ContactList:  contactList,
+		Count:        int64(len(contactList)),
+	})
+}
+
+func (s *Server) handleSyncCheck(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loggedOut {
+		fmt.Fprint(w, `window.synccheck={retcode:"1101",selector:"0"}`)
+		return
+	}
+	if r.URL.Query().Get("sid") != s.sid || r.URL.Query().Get("uin") != s.uin {
+		fmt.Fprint(w, `window.synccheck={retcode:"1102",selector:"0"}`)
+		return
+	}
+	if _, err := s.wait(r.Context(), func() bool {
+		return s.hasPending() || s.loggedOut
+	}); err != nil {
+		return
+	}
+	switch {
+	case s.loggedOut:
+		fmt.Fprint(w, `window.synccheck={retcode:"1101",selector:"0"}`)
+	case s.hasPending():
+		fmt.Fprint(w, `window.synccheck={retcode:"0",selector:"2"}`)
+	default:
+		fmt.Fprint(w, `window.synccheck={retcode:"0",selector:"0"}`)
+	}
+}
+
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	ret, err := s.decodeBody(r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret != 0 {
+		writeJSON(w, datastruct.WebwxSyncRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	result, more := s.sync()
+	resp := datastruct.WebwxSyncRespond{
+		BaseResponse:           baseResponse(0),
+		AddMsgCount:            int64(len(result.AddMessages)),
+		AddMsgList:             result.AddMessages,
+		DelContactCount:        int64(len(result.DelContacts)),
+		DelContactList:         result.DelContacts,
+		ModContactCount:        int64(len(result.ModContacts)),
+		ModContactList:         result.ModContacts,
+		SKey:                   s.skey,
+		ModChatRoomMemberCount: int64(len(result.ModChatRoomMembers)),
+		ModChatRoomMemberList:  result.ModChatRoomMembers,
+		Profile:                result.Profile,
+	}
+	if more {
+		resp.ContinueFlag = 1
+	}
+	resp.SyncKey = s.syncKey()
+	resp.SyncCheckKey = s.syncKey()
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleBaseRequestOnly(w http.ResponseWriter, r *http.Request) {
+	ret, err := s.decodeBody(r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, datastruct.ModifyRemarkRespond{BaseResponse: baseResponse(ret)})
+}
+
+func (s *Server) handleOpLog(w http.ResponseWriter, r *http.Request) {
+	var req datastruct.ModifyRemarkRequest
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret == 0 && !s.modifyRemarkName(req.UserName, req.RemarkName) {
+		ret = 1
+	}
+	writeJSON(w, datastruct.ModifyRemarkRespond{BaseResponse: baseResponse(ret)})
+}
+
+func (s *Server) handleVerifyUser(w http.ResponseWriter, r *http.Request) {
+	var req datastruct.VerifyUserRequest
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret == 0 {
+		for _, item := range req.VerifyUserList {
+			s.verifyUser(item.Value)
+		}
+	}
+	writeJSON(w, datastruct.ModifyRemarkRespond{BaseResponse: baseResponse(ret)})
+}
+
+func (s *Server) handleUpdateChatRoom(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChatRoomName     string
+		NewTopic         string
+		AddMemberList    string
+		InviteMemberList string
+		DelMemberList    string
+	}
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret != 0 {
+		writeJSON(w, datastruct.UpdateChatRoomMemberRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	var memberList []datastruct.Member
+	ok := true
+	switch r.URL.Query().Get("fun") {
+	case "modtopic":
+		_, ok = s.contacts[req.ChatRoomName]
+		if ok {
+			s.modifyChatRoomTopic(req.ChatRoomName, req.NewTopic)
+		}
+	case "addmember":
+		memberList, ok = s.addChatRoomMember(req.ChatRoomName, strings.Split(req.AddMemberList, ","))
+	case "invitemember":
+		memberList, ok = s.inviteChatRoomMember(req.ChatRoomName, strings.Split(req.InviteMemberList, ","))
+	case "delmember":
+		ok = s.delChatRoomMember(req.ChatRoomName, strings.Split(req.DelMemberList, ","))
+	}
+	if !ok {
+		writeJSON(w, datastruct.UpdateChatRoomMemberRespond{BaseResponse: baseResponse(1)})
+		return
+	}
+	writeJSON(w, datastruct.UpdateChatRoomMemberRespond{
+		BaseResponse: baseResponse(0),
+		MemberCount:  int64(len(memberList)),
+		MemberList:   memberList,
+	})
+}
+
+func (s *Server) handleCreateChatRoom(w http.ResponseWriter, r *http.Request) {
+	var req datastruct.CreateChatRoomRequest
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret != 0 {
+		writeJSON(w, datastruct.CreateChatRoomRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	var memberUserNames []string
+	for _, item := range req.MemberList {
+		memberUserNames = append(memberUserNames, item.UserName)
+	}
+	chatroom := s.createChatRoom(req.Topic, memberUserNames)
+	writeJSON(w, datastruct.CreateChatRoomRespond{
+		BaseResponse: baseResponse(0),
+		Topic:        req.Topic,
+		MemberCount:  int64(len(chatroom.MemberList)),
+		MemberList:   chatroom.MemberList,
+		ChatRoomName: chatroom.UserName,
+	})
+}
+
+func (s *Server) handleSendMsg(w http.ResponseWriter, r *http.Request) {
+	var req datastruct.SendMessageRequest
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret != 0 || req.Msg == nil {
+		if ret == 0 {
+			ret = 1
+		}
+		writeJSON(w, datastruct.SendMessageRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	msgID := s.send(r.URL.Path, *req.Msg)
+	writeJSON(w, datastruct.SendMessageRespond{
+		BaseResponse: baseResponse(0),
+		LocalID:      req.Msg.LocalID,
+		MsgID:        msgID,
+	})
+}
+
+func (s *Server) handleRevokeMsg(w http.ResponseWriter, r *http.Request) {
+	var req datastruct.RevokeMessageRequest
+	ret, err := s.decodeBody(r, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ret == 0 {
+		s.revoke(req)
+	}
+	writeJSON(w, datastruct.RevokeMessageRespond{BaseResponse: baseResponse(ret)})
+}
+
+func (s *Server) handleUploadMedia(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req datastruct.UploadMediaRequest
+	err = json.Unmarshal([]byte(r.FormValue("uploadmediarequest")), &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("filename")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, _ := ioutil.ReadAll(file)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := s.checkBaseRequest(req.BaseRequest)
+	if ret == 0 && r.FormValue("webwx_data_ticket") != s.dataTicket {
+		ret = api.RetSessionInvalid
+	}
+	if ret != 0 {
+		writeJSON(w, datastruct.UploadMediaRespond{BaseResponse: baseResponse(ret)})
+		return
+	}
+	id := r.FormValue("id")
+	item, ok := s.uploading[id]
+	if !ok {
+		item = &uploadingItem{}
+		s.uploading[id] = item
+	}
+	item.data = append(item.data, data...)
+	chunks, _ := strconv.Atoi(r.FormValue("chunks"))
+	chunk, _ := strconv.Atoi(r.FormValue("chunk"))
+	resp := datastruct.UploadMediaRespond{
+		BaseResponse: baseResponse(0),
+		StartPos:     int64(len(item.data)),
+	}
+	if chunks <= 1 || chunk == chunks-1 {
+		// 最后一个分片上传完成
+		delete(s.uploading, id)
+		resp.MediaID = s.upload(item.data, r.FormValue("type"))
+		resp.EncryFileName = header.Filename
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request, key string) {
+	item, ok := s.getMedia(key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if item.contentType != "" {
+		w.Header().Set("Content-Type", item.contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(item.data)))
+	w.Write(item.data)
+}
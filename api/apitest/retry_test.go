@@ -0,0 +1,165 @@
+package apitest_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/api/apitest"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/pkg/errors"
+)
+
+// faultTransport 按照接口注入错误的Transport
+type faultTransport struct {
+	dialErrors  map[string]int // 接口 => 剩余的连接失败次数
+	rateLimited map[string]bool
+	failAfter   map[string]int // 接口 => 剩余的成功次数，用完后一直连接失败
+	calls       map[string]int
+}
+
+func (f *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := path.Base(req.URL.Path)
+	f.calls[endpoint]++
+	if f.dialErrors[endpoint] > 0 {
+		f.dialErrors[endpoint]--
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: net.UnknownNetworkError("fault")}
+	}
+	if n, ok := f.failAfter[endpoint]; ok {
+		if n == 0 {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: net.UnknownNetworkError("fault")}
+		}
+		f.failAfter[endpoint] = n - 1
+	}
+	if f.rateLimited[endpoint] {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"BaseResponse":{"Ret":1205,"ErrMsg":""}}`)),
+			Request:    req,
+		}, nil
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRetryAndCircuitBreak(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	transport := &faultTransport{
+		dialErrors:  map[string]int{"webwxsendmsg": 2},
+		rateLimited: map[string]bool{},
+		calls:       map[string]int{},
+	}
+	var attempts []api.RetryAttempt
+	var broken []string
+	policy := api.DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.OnAttempt = func(attempt api.RetryAttempt) {
+		attempts = append(attempts, attempt)
+	}
+	policy.OnCircuitBreak = func(endpoint string, cooldown time.Duration) {
+		broken = append(broken, endpoint)
+	}
+	wxAPI := login(t, srv, transport, policy)
+
+	// 连接失败时即使非幂等的接口也会重试
+	_, _, _, err := wxAPI.SendTextMessage("@self", "@friend", "hi")
+	if err != nil {
+		t.Fatalf("SendTextMessage expect success after retry, got %v", err)
+	}
+	if transport.calls["webwxsendmsg"] != 3 || len(attempts) != 2 || !attempts[0].Retrying || attempts[1].Attempt != 2 {
+		t.Fatalf("expect 2 failed attempts before success, got calls %d attempts %#v", transport.calls["webwxsendmsg"], attempts)
+	}
+
+	// 操作频繁时熔断，熔断期间不再请求服务器
+	transport.rateLimited["webwxsendmsg"] = true
+	_, _, _, err = wxAPI.SendTextMessage("@self", "@friend", "hi")
+	if !api.IsRateLimited(err) || len(broken) != 1 || broken[0] != "webwxsendmsg" {
+		t.Fatalf("expect rate limited error and circuit break, got %v(%v)", err, broken)
+	}
+	calls := transport.calls["webwxsendmsg"]
+	_, _, _, err = wxAPI.SendTextMessage("@self", "@friend", "hi")
+	if !api.IsRateLimited(err) || errors.Cause(err) != api.ErrCircuitOpen || transport.calls["webwxsendmsg"] != calls {
+		t.Fatalf("expect request rejected by circuit breaker, got %v", err)
+	}
+	// 其他接口不受影响
+	_, _, _, err = wxAPI.SyncCheck()
+	if err != nil {
+		t.Fatalf("SyncCheck error: %v", err)
+	}
+}
+
+// slowTransport 每次请求前等待delay的Transport，模拟响应缓慢的服务器
+type slowTransport struct {
+	delay time.Duration
+}
+
+func (s *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(s.delay)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTimeout(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PollTimeout = 10 * time.Millisecond
+	transport := &slowTransport{}
+	wxAPI := login(t, srv, transport, api.Timeout(100*time.Millisecond), &api.RetryPolicy{MaxAttempts: 1})
+	transport.delay = 300 * time.Millisecond
+	// 自定义Transport时普通请求仍然受整体超时时间限制
+	_, _, _, err := wxAPI.WebwxInit()
+	if err == nil {
+		t.Fatal("WebwxInit expect timeout error")
+	}
+	// 下载媒体文件不受整体超时时间限制
+	srv.SetMedia("10001", []byte("image"), "image/jpeg")
+	imgData, err := wxAPI.SaveMessageImage("10001")
+	if err != nil || string(imgData) != "image" {
+		t.Fatalf("SaveMessageImage expect image, got %q(%v)", imgData, err)
+	}
+}
+
+func TestSyncContinueFlagPartialFailure(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.SyncBatchSize = 2
+	transport := &faultTransport{
+		dialErrors:  map[string]int{},
+		rateLimited: map[string]bool{},
+		failAfter:   map[string]int{},
+		calls:       map[string]int{},
+	}
+	policy := api.DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	wxAPI := login(t, srv, transport, policy)
+	for _, content := range []string{"1", "2", "3", "4", "5"} {
+		srv.InjectMessage(datastruct.Message{FromUserName: "@friend", MsgType: datastruct.TextMsg, Content: content})
+	}
+	// 第一次请求成功后同步失败，已获取的消息与错误一同返回
+	transport.failAfter["webwxsync"] = 1
+	result, _, err := wxAPI.WebwxSyncDetail()
+	if err == nil {
+		t.Fatal("expect error when continued sync fails")
+	}
+	if len(result.AddMessages) != 2 || result.AddMessages[0].Content != "1" || result.AddMessages[1].Content != "2" {
+		t.Fatalf("expect messages fetched before failure returned with error, got %#v", result.AddMessages)
+	}
+	// 剩余的消息在下次同步时获取
+	delete(transport.failAfter, "webwxsync")
+	result, _, err = wxAPI.WebwxSyncDetail()
+	if err != nil {
+		t.Fatalf("WebwxSyncDetail error: %v", err)
+	}
+	var contents string
+	for _, msg := range result.AddMessages {
+		contents += msg.Content
+	}
+	if contents != "345" {
+		t.Fatalf("expect remaining messages on next sync, got %q", contents)
+	}
+}
@@ -0,0 +1,142 @@
+// Package apitest 提供了一个基于httptest的微信网页版模拟服务器
+// 服务器实现了api包所使用的协议，可以在不连接真实微信服务器的情况下测试登陆、同步、收发消息等流程
+// 不需要测试协议层时可以使用内存实现的FakeAPI，两者共用State，行为一致
+package apitest
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/ikuiki/wwdk/api"
+)
+
+// LoginState 模拟服务器的扫码登陆状态
+type LoginState int32
+
+const (
+	// LoginStateWaitForScan 等待扫码
+	LoginStateWaitForScan LoginState = 0
+	// LoginStateScaned 已扫码，等待确认
+	LoginStateScaned LoginState = 1
+	// LoginStateConfirmed 已确认登陆
+	LoginStateConfirmed LoginState = 2
+	// LoginStateExpired 二维码已失效
+	LoginStateExpired LoginState = 3
+)
+
+// uploadingItem 正在分片上传的文件
+type uploadingItem struct {
+	data []byte
+}
+
+// Server 微信网页版模拟服务器
+// 联系人、消息等账号状态以及注入事件、检查请求的方法由内嵌的State提供，与FakeAPI一致
+type Server struct {
+	*State
+	server *httptest.Server
+
+	// 登陆相关
+	uuid               string
+	loginState         LoginState
+	reportedLoginState LoginState
+	autoConfirm        bool
+	avatar             string
+
+	// 登陆凭据
+	uin        string
+	sid        string
+	skey       string
+	passTicket string
+	dataTicket string
+	authTicket string
+
+	// 分片上传中的文件，key为上传时的id
+	uploading map[string]*uploadingItem
+
+	// 记录收到的请求
+	requestPaths []string
+}
+
+// NewServer 创建并启动一个模拟服务器，使用完毕后需要调用Close
+func NewServer() *Server {
+	s := &Server{
+		State:      newState(),
+		uin:        "2100000000",
+		sid:        "QQtestsid0000000",
+		skey:       "@crypt_test_skey",
+		passTicket: "test_pass_ticket",
+		dataTicket: "test_data_ticket",
+		authTicket: "test_auth_ticket",
+		avatar:     "data:img/jpg,avatar",
+		uploading:  make(map[string]*uploadingItem),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL 服务器的地址，如http://127.0.0.1:12345
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// EndpointResolver 返回将所有子系统都指向此服务器的地址解析器
+// 可作为配置传入api.NewWechatwebAPI或wwdk.NewWechatWeb
+func (s *Server) EndpointResolver() api.EndpointResolver {
+	resolver, err := api.NewStaticEndpointResolver(s.server.URL)
+	if err != nil {
+		panic(err)
+	}
+	return resolver
+}
+
+// Close 关闭服务器
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetAutoConfirm 设置是否自动确认登陆
+// 开启后获取uuid时即视为已扫码并确认登陆
+func (s *Server) SetAutoConfirm(autoConfirm bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoConfirm = autoConfirm
+}
+
+// Scan 模拟用户扫码
+func (s *Server) Scan() {
+	s.setLoginState(LoginStateScaned)
+}
+
+// Confirm 模拟用户在手机上确认登陆
+func (s *Server) Confirm() {
+	s.setLoginState(LoginStateConfirmed)
+}
+
+// ExpireQRCode 模拟二维码失效
+func (s *Server) ExpireQRCode() {
+	s.setLoginState(LoginStateExpired)
+}
+
+func (s *Server) setLoginState(state LoginState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loginState = state
+	if state == LoginStateConfirmed {
+		s.confirmLogin()
+	}
+	s.notify()
+}
+
+// LoginState 获取当前的扫码登陆状态
+func (s *Server) LoginState() LoginState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loginState
+}
+
+// RequestPaths 获取服务器收到过的请求的路径
+func (s *Server) RequestPaths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requestPaths...)
+}
@@ -0,0 +1,323 @@
+package apitest_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/api/apitest"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/pkg/errors"
+)
+
+// login 使用模拟服务器完成扫码登陆与初始化
+// @param configs 额外的api配置
+func login(t *testing.T, srv *apitest.Server, configs ...interface{}) api.WechatwebAPI {
+	wxAPI, err := api.NewWechatwebAPI(append([]interface{}{srv.EndpointResolver()}, configs...)...)
+	if err != nil {
+		t.Fatalf("NewWechatwebAPI error: %v", err)
+	}
+	uuid, _, err := wxAPI.JsLogin()
+	if err != nil {
+		t.Fatalf("JsLogin error: %v", err)
+	}
+	code, _, _, _, err := wxAPI.Login(uuid, "1")
+	if err != nil || code != "408" {
+		t.Fatalf("Login before scan expect code 408, got %s(%v)", code, err)
+	}
+	srv.Scan()
+	code, avatar, _, _, err := wxAPI.Login(uuid, "0")
+	if err != nil || code != "201" || avatar == "" {
+		t.Fatalf("Login after scan expect code 201 with avatar, got %s(%v)", code, err)
+	}
+	srv.Confirm()
+	code, _, redirectURL, _, err := wxAPI.Login(uuid, "0")
+	if err != nil || code != "200" || redirectURL == "" {
+		t.Fatalf("Login after confirm expect code 200 with redirectURL, got %s(%v)", code, err)
+	}
+	_, err = wxAPI.WebwxNewLoginPage(redirectURL)
+	if err != nil {
+		t.Fatalf("WebwxNewLoginPage error: %v", err)
+	}
+	user, _, _, err := wxAPI.WebwxInit()
+	if err != nil {
+		t.Fatalf("WebwxInit error: %v", err)
+	}
+	if user.UserName != srv.User().UserName {
+		t.Fatalf("WebwxInit user expect %s, got %s", srv.User().UserName, user.UserName)
+	}
+	return wxAPI
+}
+
+func TestLoginAndContact(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.AddContact(
+		datastruct.Contact{UserName: "@friend", NickName: "friend"},
+		datastruct.Contact{UserName: "@@room", NickName: "room", MemberList: []datastruct.Member{
+			datastruct.Member{UserName: "@self"},
+			datastruct.Member{UserName: "@friend"},
+		}},
+	)
+	wxAPI := login(t, srv)
+	contactList, _, err := wxAPI.GetContact()
+	if err != nil {
+		t.Fatalf("GetContact error: %v", err)
+	}
+	if len(contactList) != 2 {
+		t.Fatalf("GetContact expect 2 contacts, got %d", len(contactList))
+	}
+	contactList, _, err = wxAPI.BatchGetContact([]datastruct.BatchGetContactRequestListItem{
+		datastruct.BatchGetContactRequestListItem{UserName: "@@room"},
+	})
+	if err != nil {
+		t.Fatalf("BatchGetContact error: %v", err)
+	}
+	if len(contactList) != 1 || len(contactList[0].MemberList) != 2 {
+		t.Fatalf("BatchGetContact expect chatroom with 2 members, got %#v", contactList)
+	}
+	srv.BatchGetContactFailures = 1
+	_, _, err = wxAPI.BatchGetContact([]datastruct.BatchGetContactRequestListItem{
+		datastruct.BatchGetContactRequestListItem{UserName: "@@room"},
+	})
+	if apiErr, ok := api.AsAPIError(err); !ok || apiErr.Ret != 1 {
+		t.Fatalf("BatchGetContact with failure injected expect APIError with ret 1, got %v", err)
+	}
+}
+
+func TestQRCodeExpired(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	wxAPI := api.MustNewWechatwebAPI(srv.EndpointResolver())
+	uuid, _, err := wxAPI.JsLogin()
+	if err != nil {
+		t.Fatalf("JsLogin error: %v", err)
+	}
+	srv.ExpireQRCode()
+	code, _, _, _, err := wxAPI.Login(uuid, "1")
+	if err != nil || code != "400" {
+		t.Fatalf("Login after expired expect code 400, got %s(%v)", code, err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	wxAPI := login(t, srv)
+	_, selector, _, err := wxAPI.SyncCheck()
+	if err != nil || selector != "0" {
+		t.Fatalf("SyncCheck without event expect selector 0, got %s(%v)", selector, err)
+	}
+	msgID := srv.InjectMessage(datastruct.Message{
+		FromUserName: "@friend",
+		MsgType:      datastruct.TextMsg,
+		Content:      "hello",
+	})
+	srv.ModifyContact(datastruct.Contact{UserName: "@friend", NickName: "new friend"})
+	srv.DeleteContact("@stranger")
+	_, selector, _, err = wxAPI.SyncCheck()
+	if err != nil || selector == "0" {
+		t.Fatalf("SyncCheck with event expect selector not 0, got %s(%v)", selector, err)
+	}
+	modContacts, delContacts, addMessages, _, err := wxAPI.WebwxSync()
+	if err != nil {
+		t.Fatalf("WebwxSync error: %v", err)
+	}
+	if len(addMessages) != 1 || addMessages[0].MsgID != msgID || addMessages[0].Content != "hello" {
+		t.Fatalf("WebwxSync expect injected message, got %#v", addMessages)
+	}
+	if len(modContacts) != 1 || modContacts[0].NickName != "new friend" {
+		t.Fatalf("WebwxSync expect modified contact, got %#v", modContacts)
+	}
+	if len(delContacts) != 1 || delContacts[0].UserName != "@stranger" {
+		t.Fatalf("WebwxSync expect deleted contact, got %#v", delContacts)
+	}
+	_, selector, _, err = wxAPI.SyncCheck()
+	if err != nil || selector != "0" {
+		t.Fatalf("SyncCheck after sync expect selector 0, got %s(%v)", selector, err)
+	}
+
+	srv.ModifyChatRoomMembers("@@room", datastruct.Member{UserName: "@newmember", NickName: "new member"})
+	srv.ModifyProfile(datastruct.Profile{NickName: &datastruct.BuffString{Buff: "new self"}})
+	result, _, err := wxAPI.WebwxSyncDetail()
+	if err != nil {
+		t.Fatalf("WebwxSyncDetail error: %v", err)
+	}
+	if len(result.ModChatRoomMembers) != 1 || result.ModChatRoomMembers[0].UserName != "@@room" ||
+		len(result.ModChatRoomMembers[0].MemberList) != 1 || result.ModChatRoomMembers[0].MemberList[0].UserName != "@newmember" {
+		t.Fatalf("WebwxSyncDetail expect modified chatroom member, got %#v", result.ModChatRoomMembers)
+	}
+	if result.Profile == nil || result.Profile.NickName == nil || result.Profile.NickName.Buff != "new self" {
+		t.Fatalf("WebwxSyncDetail expect modified profile, got %#v", result.Profile)
+	}
+
+	srv.ForceLogout()
+	_, _, _, err = wxAPI.SyncCheck()
+	if !api.IsLoggedOut(err) {
+		t.Fatalf("SyncCheck after logout expect logged out error, got %v", err)
+	}
+	_, _, _, _, err = wxAPI.WebwxSync()
+	apiErr, ok := api.AsAPIError(err)
+	if !ok || apiErr.Endpoint != "webwxsync" || apiErr.Ret != api.RetLoginElsewhere || !apiErr.IsSessionInvalid() || apiErr.IsRateLimited() {
+		t.Fatalf("WebwxSync after logout expect APIError with ret 1101, got %#v", err)
+	}
+}
+
+func TestSendAndRevoke(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	wxAPI := login(t, srv)
+	msgID, localID, _, err := wxAPI.SendTextMessage("@self", "@friend", "hi")
+	if err != nil {
+		t.Fatalf("SendTextMessage error: %v", err)
+	}
+	_, err = wxAPI.SendRevokeMessage("@friend", msgID, localID)
+	if err != nil {
+		t.Fatalf("SendRevokeMessage error: %v", err)
+	}
+	sent := srv.SentMessages()
+	if len(sent) != 1 || sent[0].MsgID != msgID || sent[0].Msg.Content != "hi" || sent[0].Msg.ToUserName != "@friend" {
+		t.Fatalf("unexpected sent messages: %#v", sent)
+	}
+	revoked := srv.RevokedMessages()
+	if len(revoked) != 1 || revoked[0].SvrMsgID != msgID {
+		t.Fatalf("unexpected revoked messages: %#v", revoked)
+	}
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	wxAPI := login(t, srv)
+	// 超过512K，会分片上传
+	data := bytes.Repeat([]byte("0123456789"), 60*1024)
+	mediaID, _, err := wxAPI.UploadMedia("@self", "@friend", "test.png", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("UploadMedia error: %v", err)
+	}
+	uploaded, ok := srv.Media(mediaID)
+	if !ok || !bytes.Equal(uploaded, data) {
+		t.Fatalf("uploaded media mismatch, ok=%v len=%d", ok, len(uploaded))
+	}
+	_, _, _, err = wxAPI.SendImageMessage("@self", "@friend", mediaID)
+	if err != nil {
+		t.Fatalf("SendImageMessage error: %v", err)
+	}
+	sent := srv.SentMessages()
+	if len(sent) != 1 || sent[0].Msg.MediaID != mediaID || sent[0].Path != "/cgi-bin/mmwebwx-bin/webwxsendmsgimg" {
+		t.Fatalf("unexpected sent messages: %#v", sent)
+	}
+
+	srv.SetMedia("10001", []byte("image"), "image/jpeg")
+	imgData, err := wxAPI.SaveMessageImage("10001")
+	if err != nil || string(imgData) != "image" {
+		t.Fatalf("SaveMessageImage expect image, got %q(%v)", imgData, err)
+	}
+	_, err = wxAPI.SaveMessageImage("10002")
+	if err == nil {
+		t.Fatal("SaveMessageImage of missing media expect error")
+	}
+}
+
+func TestAddAndInviteChatRoomMember(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.AddContact(datastruct.Contact{UserName: "@@room", NickName: "room", MemberList: []datastruct.Member{
+		datastruct.Member{UserName: "@self"},
+	}})
+	wxAPI := login(t, srv)
+	memberList, _, err := wxAPI.AddChatRoomMember("@@room", []string{"@friend"})
+	if err != nil || len(memberList) != 1 || memberList[0].UserName != "@friend" {
+		t.Fatalf("AddChatRoomMember expect @friend, got %#v(%v)", memberList, err)
+	}
+	if room, _ := srv.Contact("@@room"); len(room.MemberList) != 2 {
+		t.Fatalf("expect 2 members after add, got %#v", room.MemberList)
+	}
+	memberList, _, err = wxAPI.InviteChatRoomMember("@@room", []string{"@invitee"})
+	if err != nil || len(memberList) != 1 || memberList[0].UserName != "@invitee" {
+		t.Fatalf("InviteChatRoomMember expect @invitee, got %#v(%v)", memberList, err)
+	}
+	// 被邀请的联系人接受邀请前不是群成员
+	if room, _ := srv.Contact("@@room"); len(room.MemberList) != 2 {
+		t.Fatalf("expect members unchanged after invite, got %#v", room.MemberList)
+	}
+}
+
+func TestSyncContinueFlag(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.SyncBatchSize = 2
+	wxAPI := login(t, srv)
+	for _, content := range []string{"1", "2", "3", "4", "5"} {
+		srv.InjectMessage(datastruct.Message{FromUserName: "@friend", MsgType: datastruct.TextMsg, Content: content})
+	}
+	_, _, addMessages, _, err := wxAPI.WebwxSync()
+	if err != nil {
+		t.Fatalf("WebwxSync error: %v", err)
+	}
+	var contents string
+	for _, msg := range addMessages {
+		contents += msg.Content
+	}
+	if contents != "12345" {
+		t.Fatalf("expect all messages in order, got %q", contents)
+	}
+	_, selector, _, err := wxAPI.SyncCheck()
+	if err != nil || selector != "0" {
+		t.Fatalf("SyncCheck after sync expect selector 0, got %s(%v)", selector, err)
+	}
+}
+
+func TestGetContactPagination(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.ContactPageSize = 2
+	for _, userName := range []string{"@a", "@b", "@c", "@d", "@e"} {
+		srv.AddContact(datastruct.Contact{UserName: userName, NickName: userName})
+	}
+	wxAPI := login(t, srv)
+	page, nextSeq, _, err := wxAPI.GetContactPage(0)
+	if err != nil || len(page) != 2 || nextSeq == 0 {
+		t.Fatalf("GetContactPage expect first page with next seq, got %d contacts seq %d(%v)", len(page), nextSeq, err)
+	}
+	contactList, _, err := wxAPI.GetContact()
+	if err != nil || len(contactList) != 5 || contactList[4].UserName != "@e" {
+		t.Fatalf("GetContact expect all 5 contacts, got %#v(%v)", contactList, err)
+	}
+}
+
+func TestPushLogin(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	fresh, err := api.NewWechatwebAPI(srv.EndpointResolver())
+	if err != nil {
+		t.Fatalf("NewWechatwebAPI error: %v", err)
+	}
+	if _, _, err = fresh.PushLogin(); errors.Cause(err) != api.ErrNoUin {
+		t.Fatalf("PushLogin without uin expect ErrNoUin, got %v", err)
+	}
+	wxAPI := login(t, srv)
+	uuid, _, err := wxAPI.PushLogin()
+	if err != nil || uuid == "" {
+		t.Fatalf("PushLogin expect uuid, got %s(%v)", uuid, err)
+	}
+	code, _, _, _, err := wxAPI.Login(uuid, "1")
+	if err != nil || code != "201" {
+		t.Fatalf("Login after push expect code 201, got %s(%v)", code, err)
+	}
+	srv.Confirm()
+	code, _, redirectURL, _, err := wxAPI.Login(uuid, "0")
+	if err != nil || code != "200" || redirectURL == "" {
+		t.Fatalf("Login after confirm expect code 200 with redirectURL, got %s(%v)", code, err)
+	}
+	// 用户拒绝推送时返回二维码失效
+	srv.PushLoginRefused = true
+	uuid, _, err = wxAPI.PushLogin()
+	if err != nil || uuid == "" {
+		t.Fatalf("PushLogin expect uuid, got %s(%v)", uuid, err)
+	}
+	code, _, _, _, err = wxAPI.Login(uuid, "1")
+	if err != nil || code != "400" {
+		t.Fatalf("Login after push refused expect code 400, got %s(%v)", code, err)
+	}
+}
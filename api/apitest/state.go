@@ -0,0 +1,556 @@
+package apitest
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/datastruct"
+)
+
+// State 模拟的微信账号状态，包括当前用户、联系人、待同步的内容、媒体文件以及收到的请求记录
+// Server与FakeAPI都内嵌了State，两者对联系人、群聊、消息、登陆登出、联系人分页与同步分批的处理完全相同，
+// 区别只在于Server通过http协议对外提供，FakeAPI直接以WechatwebAPI的方法提供
+// State由NewServer与NewFakeAPI创建，不能单独使用
+type State struct {
+	mu sync.Mutex
+	// changed 状态变更时关闭并重新创建，用于唤醒长轮询的请求
+	changed chan struct{}
+
+	// PollTimeout 长轮询（扫码登陆、检查同步）在无状态变更时的等待时间
+	PollTimeout time.Duration
+	// ContactPageSize 获取联系人时每页返回的联系人数，为0时不分页
+	ContactPageSize int
+	// BatchGetContactLimit 批量获取联系人时每次请求最多返回MemberList的群聊数，为0时不限制
+	// 与真实服务器一致，超出的群聊仍会返回但不带MemberList
+	BatchGetContactLimit int
+	// BatchGetContactFailures 接下来的批量获取联系人请求中返回错误的次数
+	BatchGetContactFailures int
+	// SyncBatchSize 每次同步最多返回的消息数，为0时不限制
+	// 还有剩余的消息时返回ContinueFlag，需要继续同步获取
+	SyncBatchSize int
+	// PushLoginRefused 模拟用户在手机上拒绝推送登陆，等待确认时返回二维码失效
+	PushLoginRefused bool
+	// PushLoginIgnored 模拟用户一直不处理推送登陆，等待确认时始终返回等待登陆
+	PushLoginIgnored bool
+
+	loggedOut bool
+	logined   bool // 是否登陆过，登陆过才能推送登陆
+
+	// 用户与联系人
+	user         datastruct.User
+	contacts     map[string]datastruct.Contact
+	contactOrder []string
+
+	// 待同步的内容
+	syncKeyVal  int64
+	addMessages []datastruct.Message
+	modContacts []datastruct.Contact
+	delContacts []datastruct.WebwxSyncRespondDelContactListItem
+	modMembers  []datastruct.ModChatRoomMember
+	profile     *datastruct.Profile
+
+	// 媒体文件，key为MsgID/MediaID/UserName
+	media map[string]mediaItem
+
+	// 记录收到的请求
+	msgSeq             int64
+	sentMessages       []SentMessage
+	revokedMessages    []datastruct.RevokeMessageRequest
+	topicModifications []datastruct.ModifyChatRoomTopicRequest
+}
+
+// SentMessage 收到的发送消息请求
+type SentMessage struct {
+	// Path 请求的接口路径，如/cgi-bin/mmwebwx-bin/webwxsendmsg
+	Path string
+	// Msg 发送的消息
+	Msg datastruct.SendMessage
+	// MsgID 为消息生成的MsgID
+	MsgID string
+}
+
+// mediaItem 媒体文件
+type mediaItem struct {
+	data        []byte
+	contentType string
+}
+
+// newState 创建账号状态
+func newState() *State {
+	return &State{
+		changed:     make(chan struct{}),
+		PollTimeout: 200 * time.Millisecond,
+		user: datastruct.User{
+			Uin:        2100000000,
+			UserName:   "@self",
+			NickName:   "self",
+			HeadImgURL: "/cgi-bin/mmwebwx-bin/webwxgeticon?seq=0&username=@self&skey=",
+		},
+		contacts:   make(map[string]datastruct.Contact),
+		syncKeyVal: 1,
+		media:      make(map[string]mediaItem),
+		msgSeq:     1000000000000000000,
+	}
+}
+
+// notify 通知长轮询的请求状态已经变更，调用时必须持有锁
+func (s *State) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// wait 等待ready返回true，最多等待PollTimeout，调用时必须持有锁，返回时仍持有锁
+// @return ok ready是否返回了true
+// @return err 等待期间ctx取消时返回ctx的错误
+func (s *State) wait(ctx context.Context, ready func() bool) (ok bool, err error) {
+	deadline := time.After(s.PollTimeout)
+	for !ready() {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+			s.mu.Lock()
+		case <-deadline:
+			s.mu.Lock()
+			return ready(), nil
+		case <-ctx.Done():
+			s.mu.Lock()
+			return false, ctx.Err()
+		}
+	}
+	return true, nil
+}
+
+// nextMsgID 生成新的MsgID，调用时必须持有锁
+func (s *State) nextMsgID() string {
+	s.msgSeq++
+	return strconv.FormatInt(s.msgSeq, 10)
+}
+
+// putContact 保存联系人，调用时必须持有锁
+func (s *State) putContact(contact datastruct.Contact) {
+	if _, ok := s.contacts[contact.UserName]; !ok {
+		s.contactOrder = append(s.contactOrder, contact.UserName)
+	}
+	if contact.IsChatroom() {
+		contact.MemberCount = int64(len(contact.MemberList))
+	}
+	s.contacts[contact.UserName] = contact
+}
+
+// SetUser 设置当前登陆的用户
+func (s *State) SetUser(user datastruct.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// User 获取当前登陆的用户
+func (s *State) User() datastruct.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.user
+}
+
+// AddContact 添加联系人，不会产生同步事件，一般在登陆前用于准备联系人列表
+func (s *State) AddContact(contacts ...datastruct.Contact) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, contact := range contacts {
+		s.putContact(contact)
+	}
+}
+
+// ModifyContact 修改（或新增）联系人，下一次同步时客户端会收到联系人变更
+func (s *State) ModifyContact(contact datastruct.Contact) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putContact(contact)
+	s.modContacts = append(s.modContacts, s.contacts[contact.UserName])
+	s.notify()
+}
+
+// ModifyChatRoomMembers 修改（或新增）群成员，下一次同步时客户端会收到群成员变更
+// 也可用于模拟被邀请的联系人接受邀请
+func (s *State) ModifyChatRoomMembers(chatRoomName string, members ...datastruct.Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chatroom, ok := s.contacts[chatRoomName]
+	if !ok {
+		chatroom.UserName = chatRoomName
+	}
+	chatroom = chatroom.UpdateMembers(members)
+	s.putContact(chatroom)
+	s.modMembers = append(s.modMembers, datastruct.ModChatRoomMember{
+		UserName:    chatRoomName,
+		MemberCount: chatroom.MemberCount,
+		MemberList:  members,
+	})
+	s.notify()
+}
+
+// ModifyProfile 修改当前登陆用户的资料，下一次同步时客户端会收到资料变更
+// profile的BitFlag为0时会设置为1，否则视为没有变更
+func (s *State) ModifyProfile(profile datastruct.Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if profile.BitFlag == 0 {
+		profile.BitFlag = 1
+	}
+	s.user = s.user.ApplyProfile(profile)
+	s.profile = &profile
+	s.notify()
+}
+
+// DeleteContact 删除联系人，下一次同步时客户端会收到联系人删除
+func (s *State) DeleteContact(userName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.contacts, userName)
+	for i, name := range s.contactOrder {
+		if name == userName {
+			s.contactOrder = append(s.contactOrder[:i], s.contactOrder[i+1:]...)
+			break
+		}
+	}
+	s.delContacts = append(s.delContacts, datastruct.WebwxSyncRespondDelContactListItem{
+		UserName: userName,
+	})
+	s.notify()
+}
+
+// Contact 获取联系人
+func (s *State) Contact(userName string) (contact datastruct.Contact, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contact, ok = s.contacts[userName]
+	return
+}
+
+// InjectMessage 注入一条新消息，下一次同步时客户端会收到此消息
+// 如果消息未设置MsgID、ToUserName、CreateTime，会自动补全
+// @return msgID 消息的MsgID
+func (s *State) InjectMessage(msg datastruct.Message) (msgID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.MsgID == "" {
+		msg.MsgID = s.nextMsgID()
+	}
+	if msg.ToUserName == "" {
+		msg.ToUserName = s.user.UserName
+	}
+	if msg.CreateTime == 0 {
+		msg.CreateTime = time.Now().Unix()
+	}
+	s.addMessages = append(s.addMessages, msg)
+	s.notify()
+	return msg.MsgID
+}
+
+// ForceLogout 模拟用户在手机上退出网页版登陆
+// 之后检查同步返回1101，其他请求返回api.IsLoggedOut为true的错误（Ret=1101）
+func (s *State) ForceLogout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logout()
+}
+
+// logout 退出登陆并唤醒长轮询的请求，调用时必须持有锁
+func (s *State) logout() {
+	s.loggedOut = true
+	s.notify()
+}
+
+// SetMedia 设置媒体文件
+// 图片、音频、视频消息的key为MsgID，附件的key为MediaID，头像的key为UserName
+func (s *State) SetMedia(key string, data []byte, contentType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.media[key] = mediaItem{
+		data:        data,
+		contentType: contentType,
+	}
+}
+
+// Media 获取媒体文件，上传的文件也可以通过MediaID获取
+func (s *State) Media(key string) (data []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.media[key]
+	return item.data, ok
+}
+
+// getMedia 获取媒体文件的内容与类型
+func (s *State) getMedia(key string) (item mediaItem, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok = s.media[key]
+	return
+}
+
+// SentMessages 获取客户端发送过的消息（包括文字、图片、视频、文件、动图）
+func (s *State) SentMessages() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SentMessage(nil), s.sentMessages...)
+}
+
+// RevokedMessages 获取客户端撤回过的消息
+func (s *State) RevokedMessages() []datastruct.RevokeMessageRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]datastruct.RevokeMessageRequest(nil), s.revokedMessages...)
+}
+
+// TopicModifications 获取客户端修改过的群名
+func (s *State) TopicModifications() []datastruct.ModifyChatRoomTopicRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]datastruct.ModifyChatRoomTopicRequest(nil), s.topicModifications...)
+}
+
+// confirmLogin 用户确认登陆，调用时必须持有锁
+func (s *State) confirmLogin() {
+	s.loggedOut = false
+}
+
+// pushLoginState 推送登陆后手机上的登陆状态，调用时必须持有锁
+// @param accepted 用户在手机上确认推送后的状态
+func (s *State) pushLoginState(accepted LoginState) LoginState {
+	switch {
+	case s.PushLoginRefused:
+		return LoginStateExpired
+	case s.PushLoginIgnored:
+		return LoginStateWaitForScan
+	default:
+		return accepted
+	}
+}
+
+// webwxInit 初始化，返回当前用户与群聊（不包含群成员），调用时必须持有锁
+// 与真实服务器一样，init只返回部分联系人（此处为群聊）
+func (s *State) webwxInit() (user datastruct.User, contactList []datastruct.Contact) {
+	for _, userName := range s.contactOrder {
+		contact := s.contacts[userName]
+		if contact.IsChatroom() {
+			contact.MemberList = nil
+			contactList = append(contactList, contact)
+		}
+	}
+	s.logined = true
+	return s.user, contactList
+}
+
+// contactList 获取不包含群成员的联系人列表，调用时必须持有锁
+func (s *State) contactList() (contactList []datastruct.Contact) {
+	for _, userName := range s.contactOrder {
+		contact := s.contacts[userName]
+		contact.MemberList = nil
+		contactList = append(contactList, contact)
+	}
+	return
+}
+
+// contactPage 获取一页不包含群成员的联系人，每页的大小由ContactPageSize决定，调用时必须持有锁
+// @param seq 本页第一个联系人的下标
+// @return nextSeq 下一页的seq，没有下一页时为0
+func (s *State) contactPage(seq int64) (page []datastruct.Contact, nextSeq int64) {
+	contactList := s.contactList()
+	if seq < 0 || seq >= int64(len(contactList)) {
+		return nil, 0
+	}
+	page = contactList[seq:]
+	if s.ContactPageSize > 0 && len(page) > s.ContactPageSize {
+		return page[:s.ContactPageSize], seq + int64(s.ContactPageSize)
+	}
+	return page, 0
+}
+
+// batchGetContact 获取联系人的完整信息，调用时必须持有锁
+// @return ok 为false时表示按照BatchGetContactFailures返回错误
+func (s *State) batchGetContact(contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, ok bool) {
+	if s.BatchGetContactFailures > 0 {
+		s.BatchGetContactFailures--
+		return nil, false
+	}
+	for i, item := range contactItemList {
+		if contact, ok := s.contacts[item.UserName]; ok {
+			if s.BatchGetContactLimit > 0 && i >= s.BatchGetContactLimit {
+				contact.MemberList = nil
+			}
+			contactList = append(contactList, contact)
+		}
+	}
+	return contactList, true
+}
+
+// hasPending 是否有待同步的内容，调用时必须持有锁
+func (s *State) hasPending() bool {
+	return len(s.addMessages) > 0 || len(s.modContacts) > 0 || len(s.delContacts) > 0 ||
+		len(s.modMembers) > 0 || s.profile != nil
+}
+
+// syncKey 当前的SyncKey，调用时必须持有锁
+func (s *State) syncKey() *datastruct.SyncKey {
+	return &datastruct.SyncKey{
+		Count: 1,
+		List: []datastruct.SyncKeyItem{
+			datastruct.SyncKeyItem{Key: 1, Val: s.syncKeyVal},
+		},
+	}
+}
+
+// sync 取出待同步的内容，消息最多取出SyncBatchSize条，调用时必须持有锁
+// @return more 是否还有剩余的消息
+func (s *State) sync() (result api.SyncResult, more bool) {
+	addMessages, remaining := s.addMessages, []datastruct.Message(nil)
+	if s.SyncBatchSize > 0 && len(addMessages) > s.SyncBatchSize {
+		addMessages, remaining = addMessages[:s.SyncBatchSize], addMessages[s.SyncBatchSize:]
+	}
+	result = api.SyncResult{
+		ModContacts:        s.modContacts,
+		DelContacts:        s.delContacts,
+		AddMessages:        addMessages,
+		ModChatRoomMembers: s.modMembers,
+		Profile:            s.profile,
+	}
+	s.addMessages, s.delContacts, s.modContacts = remaining, nil, nil
+	s.modMembers, s.profile = nil, nil
+	s.syncKeyVal++
+	return result, len(remaining) > 0
+}
+
+// modifyRemarkName 修改联系人备注，调用时必须持有锁
+func (s *State) modifyRemarkName(userName, remarkName string) (ok bool) {
+	contact, ok := s.contacts[userName]
+	if !ok {
+		return false
+	}
+	contact.RemarkName = remarkName
+	s.putContact(contact)
+	return true
+}
+
+// verifyUser 通过好友验证，联系人不存在时添加，调用时必须持有锁
+func (s *State) verifyUser(userName string) {
+	if _, ok := s.contacts[userName]; !ok {
+		s.putContact(datastruct.Contact{UserName: userName})
+	}
+}
+
+// memberList 根据UserName生成群成员列表，调用时必须持有锁
+func (s *State) memberList(userNames []string) (memberList []datastruct.Member) {
+	for _, userName := range userNames {
+		member := datastruct.Member{UserName: userName}
+		if contact, ok := s.contacts[userName]; ok {
+			member.NickName = contact.NickName
+		}
+		memberList = append(memberList, member)
+	}
+	return
+}
+
+// createChatRoom 创建群聊，调用时必须持有锁
+func (s *State) createChatRoom(topic string, memberUserNames []string) (chatroom datastruct.Contact) {
+	chatroom = datastruct.Contact{
+		UserName:   "@@chatroom_" + s.nextMsgID(),
+		NickName:   topic,
+		MemberList: s.memberList(memberUserNames),
+	}
+	s.putContact(chatroom)
+	return s.contacts[chatroom.UserName]
+}
+
+// modifyChatRoomTopic 修改群名，调用时必须持有锁
+func (s *State) modifyChatRoomTopic(chatRoomName, newTopic string) {
+	s.topicModifications = append(s.topicModifications, datastruct.ModifyChatRoomTopicRequest{
+		ChatRoomName: chatRoomName,
+		NewTopic:     newTopic,
+	})
+	if chatroom, ok := s.contacts[chatRoomName]; ok {
+		chatroom.NickName = newTopic
+		s.putContact(chatroom)
+	}
+}
+
+// addChatRoomMember 添加群成员，调用时必须持有锁
+// @return ok 群聊是否存在
+func (s *State) addChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, ok bool) {
+	chatroom, ok := s.contacts[chatRoomName]
+	if !ok {
+		return nil, false
+	}
+	memberList = s.memberList(userNames)
+	chatroom.MemberList = append(chatroom.MemberList, memberList...)
+	s.putContact(chatroom)
+	return memberList, true
+}
+
+// inviteChatRoomMember 邀请群成员，调用时必须持有锁
+// 被邀请的联系人需要接受邀请后才会成为群成员，因此不修改群成员，可通过ModifyChatRoomMembers模拟接受邀请
+// @return ok 群聊是否存在
+func (s *State) inviteChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, ok bool) {
+	if _, ok = s.contacts[chatRoomName]; !ok {
+		return nil, false
+	}
+	return s.memberList(userNames), true
+}
+
+// delChatRoomMember 移除群成员，调用时必须持有锁
+// @return ok 群聊是否存在
+func (s *State) delChatRoomMember(chatRoomName string, userNames []string) (ok bool) {
+	chatroom, ok := s.contacts[chatRoomName]
+	if !ok {
+		return false
+	}
+	delMap := make(map[string]bool)
+	for _, userName := range userNames {
+		delMap[userName] = true
+	}
+	var memberList []datastruct.Member
+	for _, member := range chatroom.MemberList {
+		if !delMap[member.UserName] {
+			memberList = append(memberList, member)
+		}
+	}
+	chatroom.MemberList = memberList
+	s.putContact(chatroom)
+	return true
+}
+
+// send 记录发送的消息，调用时必须持有锁
+// 消息未设置LocalID时，LocalID与ClientMsgID使用生成的MsgID
+// @return msgID 为消息生成的MsgID
+func (s *State) send(apiPath string, msg datastruct.SendMessage) (msgID string) {
+	msgID = s.nextMsgID()
+	if msg.LocalID == "" {
+		msg.LocalID = msgID
+		msg.ClientMsgID = msgID
+	}
+	s.sentMessages = append(s.sentMessages, SentMessage{
+		Path:  apiPath,
+		Msg:   msg,
+		MsgID: msgID,
+	})
+	return
+}
+
+// revoke 记录撤回的消息，调用时必须持有锁
+func (s *State) revoke(req datastruct.RevokeMessageRequest) {
+	req.BaseRequest = nil
+	s.revokedMessages = append(s.revokedMessages, req)
+}
+
+// upload 保存上传完成的文件，调用时必须持有锁
+// @return mediaID 文件的MediaID
+func (s *State) upload(data []byte, contentType string) (mediaID string) {
+	mediaID = "@crypt_upload_" + s.nextMsgID()
+	s.media[mediaID] = mediaItem{
+		data:        data,
+		contentType: contentType,
+	}
+	return
+}
@@ -2,17 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/ikuiki/wwdk/tool"
 	"github.com/pkg/errors"
 	"io/ioutil"
-	"net/http"
+	"net/url"
+	"strings"
 )
 
-// ModifyChatRoomTopic 修改聊天室标题
+// ModifyChatRoomTopicContext 修改聊天室标题
 // @param userName 要修改的群的UserName
 // @param remarkName 新的群名
-func (api *wechatwebAPI) ModifyChatRoomTopic(userName, newTopic string) (body []byte, err error) {
+func (api *wechatwebAPI) ModifyChatRoomTopicContext(ctx context.Context, userName, newTopic string) (body []byte, err error) {
 	mctReq := datastruct.ModifyChatRoomTopicRequest{
 		BaseRequest:  api.baseRequest(),
 		NewTopic:     newTopic,
@@ -20,28 +23,163 @@ func (api *wechatwebAPI) ModifyChatRoomTopic(userName, newTopic string) (body []
 	}
 	reqBody, err := json.Marshal(mctReq)
 	if err != nil {
-		return nil, errors.New("Marshal reqBody to json fail: " + err.Error())
+		return nil, newAPIError("webwxupdatechatroom", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
 	}
-	req, err := http.NewRequest("POST", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxupdatechatroom?fun=modtopic", bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxupdatechatroom?fun=modtopic", bytes.NewReader(reqBody))
 	if err != nil {
-		return nil, errors.New("create request error: " + err.Error())
+		return nil, newAPIError("webwxupdatechatroom", nil, nil, errors.Wrap(err, "create request error"))
 	}
 	resp, err := api.request(req)
 	if err != nil {
-		return nil, errors.New("request error: " + err.Error())
+		return nil, newAPIError("webwxupdatechatroom", nil, nil, errors.Wrap(err, "request error"))
 	}
 	defer resp.Body.Close()
 	var mctResp datastruct.ModifyChatRoomTopicRespond
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.New("read response body error: " + err.Error())
+		return nil, newAPIError("webwxupdatechatroom", resp, body, errors.Wrap(err, "read response body error"))
 	}
 	err = json.Unmarshal(body, &mctResp)
 	if err != nil {
-		return nil, errors.New("UnMarshal respond json fail: " + err.Error())
+		return nil, newAPIError("webwxupdatechatroom", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
 	}
 	if mctResp.BaseResponse.Ret != 0 {
-		return nil, errors.Errorf("Respond error ret(%d): %s", mctResp.BaseResponse.Ret, mctResp.BaseResponse.ErrMsg)
+		return nil, api.newRetError("webwxupdatechatroom", resp, body, mctResp.BaseResponse.Ret, mctResp.BaseResponse.ErrMsg)
 	}
 	return
 }
+
+// CreateChatRoomContext 创建群聊
+// @param topic 群名，网页版没有提供此功能，可以留空
+// @param memberUserNames 要拉入群聊的联系人的UserName
+// @return chatRoomName 新的群的UserName
+func (api *wechatwebAPI) CreateChatRoomContext(ctx context.Context, topic string, memberUserNames []string) (chatRoomName string, body []byte, err error) {
+	ccrReq := datastruct.CreateChatRoomRequest{
+		BaseRequest: api.baseRequest(),
+		MemberCount: int64(len(memberUserNames)),
+		Topic:       topic,
+	}
+	for _, userName := range memberUserNames {
+		ccrReq.MemberList = append(ccrReq.MemberList, datastruct.CreateChatRoomRequestMember{
+			UserName: userName,
+		})
+	}
+	reqBody, err := json.Marshal(ccrReq)
+	if err != nil {
+		err = newAPIError("webwxcreatechatroom", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
+		return
+	}
+	params := url.Values{}
+	params.Set("r", tool.GetWxTimeStamp())
+	params.Set("pass_ticket", api.loginInfo.PassTicket)
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxcreatechatroom?"+params.Encode(), bytes.NewReader(reqBody))
+	if err != nil {
+		err = newAPIError("webwxcreatechatroom", nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	resp, err := api.request(req)
+	if err != nil {
+		err = newAPIError("webwxcreatechatroom", nil, nil, errors.Wrap(err, "request error"))
+		return
+	}
+	defer resp.Body.Close()
+	var ccrResp datastruct.CreateChatRoomRespond
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = newAPIError("webwxcreatechatroom", resp, body, errors.Wrap(err, "read response body error"))
+		return
+	}
+	err = json.Unmarshal(body, &ccrResp)
+	if err != nil {
+		err = newAPIError("webwxcreatechatroom", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
+		return
+	}
+	if ccrResp.BaseResponse.Ret != 0 {
+		err = api.newRetError("webwxcreatechatroom", resp, body, ccrResp.BaseResponse.Ret, ccrResp.BaseResponse.ErrMsg)
+		return
+	}
+	chatRoomName = ccrResp.ChatRoomName
+	return
+}
+
+// updateChatRoomMember 调用webwxupdatechatroom接口修改群成员
+// @param fun 操作类型，addmember\invitemember\delmember
+// @param reqData 对应操作的请求
+func (api *wechatwebAPI) updateChatRoomMember(ctx context.Context, fun string, reqData interface{}) (memberList []datastruct.Member, body []byte, err error) {
+	reqBody, err := json.Marshal(reqData)
+	if err != nil {
+		err = newAPIError("webwxupdatechatroom", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
+		return
+	}
+	params := url.Values{}
+	params.Set("fun", fun)
+	params.Set("pass_ticket", api.loginInfo.PassTicket)
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxupdatechatroom?"+params.Encode(), bytes.NewReader(reqBody))
+	if err != nil {
+		err = newAPIError("webwxupdatechatroom", nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	resp, err := api.request(req)
+	if err != nil {
+		err = newAPIError("webwxupdatechatroom", nil, nil, errors.Wrap(err, "request error"))
+		return
+	}
+	defer resp.Body.Close()
+	var ucrResp datastruct.UpdateChatRoomMemberRespond
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = newAPIError("webwxupdatechatroom", resp, body, errors.Wrap(err, "read response body error"))
+		return
+	}
+	err = json.Unmarshal(body, &ucrResp)
+	if err != nil {
+		err = newAPIError("webwxupdatechatroom", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
+		return
+	}
+	if ucrResp.BaseResponse.Ret != 0 {
+		err = api.newRetError("webwxupdatechatroom", resp, body, ucrResp.BaseResponse.Ret, ucrResp.BaseResponse.ErrMsg)
+		return
+	}
+	memberList = ucrResp.MemberList
+	return
+}
+
+// AddChatRoomMemberContext 添加群成员
+// @param chatRoomName 目标群的UserName
+// @param userNames 要添加的联系人的UserName
+// @return memberList 被添加的成员
+func (api *wechatwebAPI) AddChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	return api.updateChatRoomMember(ctx, "addmember", datastruct.AddChatRoomMemberRequest{
+		BaseRequest:   api.baseRequest(),
+		AddMemberList: strings.Join(userNames, ","),
+		ChatRoomName:  chatRoomName,
+	})
+}
+
+// InviteChatRoomMemberContext 邀请群成员
+// 群成员较多时无法直接添加，需要发送邀请
+// @param chatRoomName 目标群的UserName
+// @param userNames 要邀请的联系人的UserName
+// @return memberList 被邀请的成员
+func (api *wechatwebAPI) InviteChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	return api.updateChatRoomMember(ctx, "invitemember", datastruct.InviteChatRoomMemberRequest{
+		BaseRequest:      api.baseRequest(),
+		InviteMemberList: strings.Join(userNames, ","),
+		ChatRoomName:     chatRoomName,
+	})
+}
+
+// DelChatRoomMemberContext 移除群成员
+// 需要为群管理员
+// @param chatRoomName 目标群的UserName
+// @param userNames 要移除的群成员的UserName
+func (api *wechatwebAPI) DelChatRoomMemberContext(ctx context.Context, chatRoomName string, userNames []string) (body []byte, err error) {
+	_, body, err = api.updateChatRoomMember(ctx, "delmember", datastruct.DelChatRoomMemberRequest{
+		BaseRequest:   api.baseRequest(),
+		DelMemberList: strings.Join(userNames, ","),
+		ChatRoomName:  chatRoomName,
+	})
+	return
+}
@@ -2,24 +2,56 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/ikuiki/wwdk/datastruct"
 	"github.com/ikuiki/wwdk/tool"
 	"github.com/pkg/errors"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"strconv"
 )
 
-// GetContact 获取联系人
+// GetContactContext 获取联系人
 // 注：坑！此处获取到的居然不是完整的联系人，必须和init中获取到的合并后才是完整的联系人列表
+// 联系人较多时服务器会分页返回，此方法会获取全部分页
 // @return contact 联系人列表（需要与wxInit获得的列表合并才是完整联系人列表）
-func (api *wechatwebAPI) GetContact() (contactList []datastruct.Contact, body []byte, err error) {
+// @return body 最后一页的原始响应
+func (api *wechatwebAPI) GetContactContext(ctx context.Context) (contactList []datastruct.Contact, body []byte, err error) {
+	var seq int64
+	seen := make(map[int64]bool)
+	for {
+		seen[seq] = true
+		var pageList []datastruct.Contact
+		pageList, seq, body, err = api.GetContactPageContext(ctx, seq)
+		if err != nil {
+			return
+		}
+		contactList = append(contactList, pageList...)
+		if seq == 0 || seen[seq] {
+			// 获取完毕，或服务器返回了重复的seq
+			return
+		}
+	}
+}
+
+// GetContactPageContext 获取一页联系人
+// @param seq 分页序号，第一页为0
+// @return contactList 本页的联系人
+// @return nextSeq 下一页的序号，为0时表示已经是最后一页
+func (api *wechatwebAPI) GetContactPageContext(ctx context.Context, seq int64) (contactList []datastruct.Contact, nextSeq int64, body []byte, err error) {
 	params := url.Values{}
 	params.Set("r", tool.GetWxTimeStamp())
-	resp, err := api.client.Get("https://" + api.apiDomain + "/cgi-bin/mmwebwx-bin/webwxgetcontact?" + params.Encode())
+	params.Set("seq", strconv.FormatInt(seq, 10))
+	params.Set("skey", api.loginInfo.SKey)
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxgetcontact?"+params.Encode(), nil)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxgetcontact", nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	resp, err := api.client.Do(req)
+	if err != nil {
+		err = newAPIError("webwxgetcontact", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
@@ -27,22 +59,22 @@ func (api *wechatwebAPI) GetContact() (contactList []datastruct.Contact, body []
 	respStruct := datastruct.GetContactRespond{}
 	err = json.Unmarshal(body, &respStruct)
 	if err != nil {
-		err = errors.New("respond json Unmarshal to struct fail: " + err.Error())
+		err = newAPIError("webwxgetcontact", resp, body, errors.Wrap(err, "respond json Unmarshal to struct fail"))
 		return
 	}
 	if respStruct.BaseResponse.Ret != 0 {
-		err = errors.Errorf("respond ret error(%d): %s", respStruct.BaseResponse.Ret, string(body))
+		err = api.newRetError("webwxgetcontact", resp, body, respStruct.BaseResponse.Ret, respStruct.BaseResponse.ErrMsg)
 		return
 	}
-	contactList = respStruct.MemberList
+	contactList, nextSeq = respStruct.MemberList, respStruct.Seq
 	return
 }
 
-// BatchGetContact 获取群聊的成员
+// BatchGetContactContext 获取群聊的成员
 // 在需要获取群成员时，或者是通过群成员UserName与群ChatRoomID获取群成员信息的时候可以调用此方法
 // @Param contactItemList 要获取的联系人的信息，获取好友与群成员要填UserName，获取群成员中非好友的联系人的信息需要带群的ChatRoomID
 // @return contactList 要获取的联系人的信息
-func (api *wechatwebAPI) BatchGetContact(contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error) {
+func (api *wechatwebAPI) BatchGetContactContext(ctx context.Context, contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error) {
 	dataStruct := datastruct.BatchGetContactRequest{
 		BaseRequest: api.baseRequest(),
 		List:        contactItemList,
@@ -53,17 +85,21 @@ func (api *wechatwebAPI) BatchGetContact(contactItemList []datastruct.BatchGetCo
 	}
 	reqBody, err := json.Marshal(dataStruct)
 	if err != nil {
-		err = errors.New("json.Marshal error: " + err.Error())
+		err = newAPIError("webwxbatchgetcontact", nil, nil, errors.Wrap(err, "json.Marshal error"))
 		return
 	}
 	params := url.Values{}
 	params.Set("type", "ex")
 	params.Set("r", tool.GetWxTimeStamp())
-	resp, err := api.client.Post("https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxbatchgetcontact?"+params.Encode(),
-		"application/json;charset=UTF-8",
-		bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxbatchgetcontact?"+params.Encode(), bytes.NewReader(reqBody))
+	if err != nil {
+		err = newAPIError("webwxbatchgetcontact", nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	resp, err := api.client.Do(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxbatchgetcontact", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
@@ -71,21 +107,21 @@ func (api *wechatwebAPI) BatchGetContact(contactItemList []datastruct.BatchGetCo
 	respStruct := datastruct.BatchGetContactResponse{}
 	err = json.Unmarshal(body, &respStruct)
 	if err != nil {
-		err = errors.New("respond json Unmarshal to struct fail: " + err.Error())
+		err = newAPIError("webwxbatchgetcontact", resp, body, errors.Wrap(err, "respond json Unmarshal to struct fail"))
 		return
 	}
 	if respStruct.BaseResponse.Ret != 0 {
-		err = errors.Errorf("respond ret error(%d): %s", respStruct.BaseResponse.Ret, string(body))
+		err = api.newRetError("webwxbatchgetcontact", resp, body, respStruct.BaseResponse.Ret, respStruct.BaseResponse.ErrMsg)
 		return
 	}
 	contactList = respStruct.ContactList
 	return
 }
 
-// ModifyUserRemakName 修改联系人备注
+// ModifyUserRemakNameContext 修改联系人备注
 // @param userName 要修改的联系人的UserName
 // @param remarkName 新的备注
-func (api *wechatwebAPI) ModifyUserRemakName(userName, remarkName string) (body []byte, err error) {
+func (api *wechatwebAPI) ModifyUserRemakNameContext(ctx context.Context, userName, remarkName string) (body []byte, err error) {
 	murReq := datastruct.ModifyRemarkRequest{
 		BaseRequest: api.baseRequest(),
 		CmdID:       2,
@@ -94,33 +130,91 @@ func (api *wechatwebAPI) ModifyUserRemakName(userName, remarkName string) (body
 	}
 	reqBody, err := json.Marshal(murReq)
 	if err != nil {
-		err = errors.New("Marshal reqBody to json fail: " + err.Error())
+		err = newAPIError("webwxoplog", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
 		return
 	}
-	req, err := http.NewRequest("POST", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxoplog", bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxoplog", bytes.NewReader(reqBody))
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxoplog", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxoplog", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
 	var murResp datastruct.ModifyRemarkRespond
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.New("read response body error: " + err.Error())
+		err = newAPIError("webwxoplog", resp, body, errors.Wrap(err, "read response body error"))
 		return
 	}
 	err = json.Unmarshal(body, &murResp)
 	if err != nil {
-		err = errors.New("UnMarshal respond json fail: " + err.Error())
+		err = newAPIError("webwxoplog", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
 		return
 	}
 	if murResp.BaseResponse.Ret != 0 {
-		err = errors.Errorf("Respond error ret(%d): %s", murResp.BaseResponse.Ret, murResp.BaseResponse.ErrMsg)
+		err = api.newRetError("webwxoplog", resp, body, murResp.BaseResponse.Ret, murResp.BaseResponse.ErrMsg)
+		return
+	}
+	return
+}
+
+// VerifyUserContext 好友验证
+// 目前仅用于接受好友请求(Opcode=3)，所需参数均可在好友请求消息(MsgType=37)的RecommendInfo中获取
+// @param opcode 操作码
+// @param userName 发起好友请求的用户的UserName
+// @param ticket 好友请求消息中RecommendInfo里的Ticket
+func (api *wechatwebAPI) VerifyUserContext(ctx context.Context, opcode datastruct.VerifyUserOpcode, userName, ticket string) (body []byte, err error) {
+	vuReq := datastruct.VerifyUserRequest{
+		BaseRequest:        api.baseRequest(),
+		Opcode:             opcode,
+		VerifyUserListSize: 1,
+		VerifyUserList: []datastruct.VerifyUserListItem{
+			datastruct.VerifyUserListItem{
+				Value:            userName,
+				VerifyUserTicket: ticket,
+			},
+		},
+		SceneListCount: 1,
+		SceneList:      []int64{33},
+		Skey:           api.loginInfo.SKey,
+	}
+	reqBody, err := json.Marshal(vuReq)
+	if err != nil {
+		err = newAPIError("webwxverifyuser", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
+		return
+	}
+	params := url.Values{}
+	params.Set("r", tool.GetWxTimeStamp())
+	params.Set("pass_ticket", api.loginInfo.PassTicket)
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxverifyuser?"+params.Encode(), bytes.NewReader(reqBody))
+	if err != nil {
+		err = newAPIError("webwxverifyuser", nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	resp, err := api.request(req)
+	if err != nil {
+		err = newAPIError("webwxverifyuser", nil, nil, errors.Wrap(err, "request error"))
+		return
+	}
+	defer resp.Body.Close()
+	var vuResp datastruct.VerifyUserRespond
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = newAPIError("webwxverifyuser", resp, body, errors.Wrap(err, "read response body error"))
+		return
+	}
+	err = json.Unmarshal(body, &vuResp)
+	if err != nil {
+		err = newAPIError("webwxverifyuser", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
+		return
+	}
+	if vuResp.BaseResponse.Ret != 0 {
+		err = api.newRetError("webwxverifyuser", resp, body, vuResp.BaseResponse.Ret, vuResp.BaseResponse.ErrMsg)
 		return
 	}
 	return
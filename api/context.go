@@ -0,0 +1,214 @@
+package api
+
+// 此文件中为不带context的方法，均以context.Background()调用对应的Context方法
+
+import (
+	"context"
+	"github.com/ikuiki/wwdk/datastruct"
+	"io"
+	"net/http"
+)
+
+// newRequest 创建绑定了ctx的请求
+func (api *wechatwebAPI) newRequest(ctx context.Context, method, url string, body io.Reader) (req *http.Request, err error) {
+	req, err = http.NewRequest(method, url, body)
+	if err != nil {
+		return
+	}
+	return req.WithContext(ctx), nil
+}
+
+// JsLogin 同JsLoginContext
+func (api *wechatwebAPI) JsLogin() (uuid string, body []byte, err error) {
+	return api.JsLoginContext(context.Background())
+}
+
+// Login 同LoginContext
+func (api *wechatwebAPI) Login(uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error) {
+	return api.LoginContext(context.Background(), uuid, tip)
+}
+
+// PushLogin 同PushLoginContext
+func (api *wechatwebAPI) PushLogin() (uuid string, body []byte, err error) {
+	return api.PushLoginContext(context.Background())
+}
+
+// WebwxNewLoginPage 同WebwxNewLoginPageContext
+func (api *wechatwebAPI) WebwxNewLoginPage(redirectURL string) (body []byte, err error) {
+	return api.WebwxNewLoginPageContext(context.Background(), redirectURL)
+}
+
+// WebwxInit 同WebwxInitContext
+func (api *wechatwebAPI) WebwxInit() (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error) {
+	return api.WebwxInitContext(context.Background())
+}
+
+// Logout 同LogoutContext
+func (api *wechatwebAPI) Logout() (body []byte, err error) {
+	return api.LogoutContext(context.Background())
+}
+
+// GetContact 同GetContactContext
+func (api *wechatwebAPI) GetContact() (contactList []datastruct.Contact, body []byte, err error) {
+	return api.GetContactContext(context.Background())
+}
+
+// GetContactPage 同GetContactPageContext
+func (api *wechatwebAPI) GetContactPage(seq int64) (contactList []datastruct.Contact, nextSeq int64, body []byte, err error) {
+	return api.GetContactPageContext(context.Background(), seq)
+}
+
+// BatchGetContact 同BatchGetContactContext
+func (api *wechatwebAPI) BatchGetContact(contactItemList []datastruct.BatchGetContactRequestListItem) (contactList []datastruct.Contact, body []byte, err error) {
+	return api.BatchGetContactContext(context.Background(), contactItemList)
+}
+
+// ModifyUserRemakName 同ModifyUserRemakNameContext
+func (api *wechatwebAPI) ModifyUserRemakName(userName, remarkName string) (body []byte, err error) {
+	return api.ModifyUserRemakNameContext(context.Background(), userName, remarkName)
+}
+
+// VerifyUser 同VerifyUserContext
+func (api *wechatwebAPI) VerifyUser(opcode datastruct.VerifyUserOpcode, userName, ticket string) (body []byte, err error) {
+	return api.VerifyUserContext(context.Background(), opcode, userName, ticket)
+}
+
+// ModifyChatRoomTopic 同ModifyChatRoomTopicContext
+func (api *wechatwebAPI) ModifyChatRoomTopic(userName, newTopic string) (body []byte, err error) {
+	return api.ModifyChatRoomTopicContext(context.Background(), userName, newTopic)
+}
+
+// CreateChatRoom 同CreateChatRoomContext
+func (api *wechatwebAPI) CreateChatRoom(topic string, memberUserNames []string) (chatRoomName string, body []byte, err error) {
+	return api.CreateChatRoomContext(context.Background(), topic, memberUserNames)
+}
+
+// AddChatRoomMember 同AddChatRoomMemberContext
+func (api *wechatwebAPI) AddChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	return api.AddChatRoomMemberContext(context.Background(), chatRoomName, userNames)
+}
+
+// InviteChatRoomMember 同InviteChatRoomMemberContext
+func (api *wechatwebAPI) InviteChatRoomMember(chatRoomName string, userNames []string) (memberList []datastruct.Member, body []byte, err error) {
+	return api.InviteChatRoomMemberContext(context.Background(), chatRoomName, userNames)
+}
+
+// DelChatRoomMember 同DelChatRoomMemberContext
+func (api *wechatwebAPI) DelChatRoomMember(chatRoomName string, userNames []string) (body []byte, err error) {
+	return api.DelChatRoomMemberContext(context.Background(), chatRoomName, userNames)
+}
+
+// SyncCheck 同SyncCheckContext
+func (api *wechatwebAPI) SyncCheck() (retCode, selector string, body []byte, err error) {
+	return api.SyncCheckContext(context.Background())
+}
+
+// WebwxSync 同WebwxSyncContext
+func (api *wechatwebAPI) WebwxSync() (modContacts []datastruct.Contact, delContacts []datastruct.WebwxSyncRespondDelContactListItem, addMessages []datastruct.Message, body []byte, err error) {
+	return api.WebwxSyncContext(context.Background())
+}
+
+// WebwxSyncDetail 同WebwxSyncDetailContext
+func (api *wechatwebAPI) WebwxSyncDetail() (result SyncResult, body []byte, err error) {
+	return api.WebwxSyncDetailContext(context.Background())
+}
+
+// StatusNotify 同StatusNotifyContext
+func (api *wechatwebAPI) StatusNotify(fromUserName, toUserName string, code int64) (body []byte, err error) {
+	return api.StatusNotifyContext(context.Background(), fromUserName, toUserName, code)
+}
+
+// SendTextMessage 同SendTextMessageContext
+func (api *wechatwebAPI) SendTextMessage(fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error) {
+	return api.SendTextMessageContext(context.Background(), fromUserName, toUserName, content)
+}
+
+// SendRevokeMessage 同SendRevokeMessageContext
+func (api *wechatwebAPI) SendRevokeMessage(toUserName, svrMsgID, clientMsgID string) (body []byte, err error) {
+	return api.SendRevokeMessageContext(context.Background(), toUserName, svrMsgID, clientMsgID)
+}
+
+// SendImageMessage 同SendImageMessageContext
+func (api *wechatwebAPI) SendImageMessage(fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	return api.SendImageMessageContext(context.Background(), fromUserName, toUserName, mediaID)
+}
+
+// SendVideoMessage 同SendVideoMessageContext
+func (api *wechatwebAPI) SendVideoMessage(fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	return api.SendVideoMessageContext(context.Background(), fromUserName, toUserName, mediaID)
+}
+
+// SendFileMessage 同SendFileMessageContext
+func (api *wechatwebAPI) SendFileMessage(fromUserName, toUserName, mediaID, fileName string, fileSize int64) (MsgID, LocalID string, body []byte, err error) {
+	return api.SendFileMessageContext(context.Background(), fromUserName, toUserName, mediaID, fileName, fileSize)
+}
+
+// SendEmoticonMessage 同SendEmoticonMessageContext
+func (api *wechatwebAPI) SendEmoticonMessage(fromUserName, toUserName, mediaID, emoticonMd5 string) (MsgID, LocalID string, body []byte, err error) {
+	return api.SendEmoticonMessageContext(context.Background(), fromUserName, toUserName, mediaID, emoticonMd5)
+}
+
+// UploadMedia 同UploadMediaContext
+func (api *wechatwebAPI) UploadMedia(fromUserName, toUserName, fileName string, file io.ReadSeeker) (mediaID string, body []byte, err error) {
+	return api.UploadMediaContext(context.Background(), fromUserName, toUserName, fileName, file)
+}
+
+// StreamMessageImage 同StreamMessageImageContext
+func (api *wechatwebAPI) StreamMessageImage(msgID string) (stream *MediaStream, err error) {
+	return api.StreamMessageImageContext(context.Background(), msgID)
+}
+
+// SaveMessageImage 同SaveMessageImageContext
+func (api *wechatwebAPI) SaveMessageImage(msgID string) (imgData []byte, err error) {
+	return api.SaveMessageImageContext(context.Background(), msgID)
+}
+
+// StreamMessageVoice 同StreamMessageVoiceContext
+func (api *wechatwebAPI) StreamMessageVoice(msgID string) (stream *MediaStream, err error) {
+	return api.StreamMessageVoiceContext(context.Background(), msgID)
+}
+
+// SaveMessageVoice 同SaveMessageVoiceContext
+func (api *wechatwebAPI) SaveMessageVoice(msgID string) (voiceData []byte, err error) {
+	return api.SaveMessageVoiceContext(context.Background(), msgID)
+}
+
+// StreamMessageVideo 同StreamMessageVideoContext
+func (api *wechatwebAPI) StreamMessageVideo(msgID string) (stream *MediaStream, err error) {
+	return api.StreamMessageVideoContext(context.Background(), msgID)
+}
+
+// SaveMessageVideo 同SaveMessageVideoContext
+func (api *wechatwebAPI) SaveMessageVideo(msgID string) (videoData []byte, err error) {
+	return api.SaveMessageVideoContext(context.Background(), msgID)
+}
+
+// StreamMessageFile 同StreamMessageFileContext
+func (api *wechatwebAPI) StreamMessageFile(mediaID, fileName, fromUserName string) (stream *MediaStream, err error) {
+	return api.StreamMessageFileContext(context.Background(), mediaID, fileName, fromUserName)
+}
+
+// SaveMessageFile 同SaveMessageFileContext
+func (api *wechatwebAPI) SaveMessageFile(mediaID, fileName, fromUserName string) (fileData []byte, err error) {
+	return api.SaveMessageFileContext(context.Background(), mediaID, fileName, fromUserName)
+}
+
+// StreamContactImg 同StreamContactImgContext
+func (api *wechatwebAPI) StreamContactImg(headImgURL string) (stream *MediaStream, err error) {
+	return api.StreamContactImgContext(context.Background(), headImgURL)
+}
+
+// SaveContactImg 同SaveContactImgContext
+func (api *wechatwebAPI) SaveContactImg(headImgURL string) (imgData []byte, err error) {
+	return api.SaveContactImgContext(context.Background(), headImgURL)
+}
+
+// StreamMemberImg 同StreamMemberImgContext
+func (api *wechatwebAPI) StreamMemberImg(userName, chatroomID string) (stream *MediaStream, err error) {
+	return api.StreamMemberImgContext(context.Background(), userName, chatroomID)
+}
+
+// SaveMemberImg 同SaveMemberImgContext
+func (api *wechatwebAPI) SaveMemberImg(userName, chatroomID string) (imgData []byte, err error) {
+	return api.SaveMemberImgContext(context.Background(), userName, chatroomID)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+	"net/url"
+)
+
+// Endpoint 微信网页版的子系统
+// 不同的子系统使用不同的域名，详见doc/protocol中关于Domain的说明
+type Endpoint int32
+
+const (
+	// EndpointLogin 登陆子系统，默认为login.{apiDomain}
+	EndpointLogin Endpoint = 1
+	// EndpointAPI 主要api子系统，默认为{apiDomain}
+	EndpointAPI Endpoint = 2
+	// EndpointWebpush 同步子系统，默认为webpush.{apiDomain}
+	EndpointWebpush Endpoint = 3
+	// EndpointFile 文件子系统，默认为file.{apiDomain}
+	EndpointFile Endpoint = 4
+)
+
+// EndpointResolver 子系统地址解析器
+// 可以通过实现此接口将api的请求指向本地服务器或者代理服务器
+type EndpointResolver interface {
+	// Resolve 根据子系统与当前的apiDomain返回该子系统的scheme与host
+	Resolve(endpoint Endpoint, apiDomain string) (scheme, host string)
+}
+
+// EndpointResolverFunc 以函数形式实现的EndpointResolver
+type EndpointResolverFunc func(endpoint Endpoint, apiDomain string) (scheme, host string)
+
+// Resolve 调用函数本身解析地址
+func (f EndpointResolverFunc) Resolve(endpoint Endpoint, apiDomain string) (scheme, host string) {
+	return f(endpoint, apiDomain)
+}
+
+// defaultEndpointResolver 默认的地址解析器，按照微信网页版的规则拼接域名
+type defaultEndpointResolver struct{}
+
+// Resolve 解析地址
+func (defaultEndpointResolver) Resolve(endpoint Endpoint, apiDomain string) (scheme, host string) {
+	switch endpoint {
+	case EndpointLogin:
+		return "https", "login." + apiDomain
+	case EndpointWebpush:
+		return "https", "webpush." + apiDomain
+	case EndpointFile:
+		return "https", "file." + apiDomain
+	default:
+		return "https", apiDomain
+	}
+}
+
+// NewStaticEndpointResolver 创建固定地址的解析器
+// 所有子系统都会指向baseURL（如http://127.0.0.1:8080），忽略登陆后返回的apiDomain，一般用于测试
+// @param baseURL 所有子系统共用的地址，需包含scheme与host
+func NewStaticEndpointResolver(baseURL string) (resolver EndpointResolver, err error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.New("parse baseURL error: " + err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("baseURL %s must contain scheme and host", baseURL)
+	}
+	return EndpointResolverFunc(func(Endpoint, string) (string, string) {
+		return u.Scheme, u.Host
+	}), nil
+}
+
+// endpoint 返回子系统的地址(scheme://host)
+func (api *wechatwebAPI) endpoint(endpoint Endpoint) string {
+	scheme, host := api.endpointResolver.Resolve(endpoint, api.apiDomain)
+	return scheme + "://" + host
+}
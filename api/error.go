@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// 微信服务器返回的错误码
+const (
+	// RetLoggedOut 已在手机上登出网页版微信
+	RetLoggedOut int64 = 1100
+	// RetLoginElsewhere 已在其他地方登陆了网页版微信
+	RetLoginElsewhere int64 = 1101
+	// RetSessionInvalid 登陆凭据（cookie、skey等）已失效
+	RetSessionInvalid int64 = 1102
+	// RetRateLimited 操作过于频繁
+	RetRateLimited int64 = 1205
+)
+
+var (
+	// ErrLogout 错误：已经登出
+	ErrLogout = errors.New("Logout")
+	// ErrNoUin 错误：没有上次登陆的Wxuin，无法推送登陆
+	ErrNoUin = errors.New("no uin for push login")
+)
+
+// APIError 请求微信服务器时发生的错误
+// 请求微信服务器出错时api包返回*APIError，可以通过IsLoggedOut等方法判断错误类型
+type APIError struct {
+	Endpoint   string // 出错的接口，如webwxsync
+	StatusCode int    // HTTP状态码，未获取到响应时为0
+	Ret        int64  // 微信返回的错误码（BaseResponse.Ret或synccheck的retcode），没有时为0
+	ErrMsg     string // 微信返回的错误信息
+	Body       []byte // 原始响应
+	Err        error  // 底层错误，如网络错误、解析错误
+}
+
+// newAPIError 创建APIError
+// @param endpoint 出错的接口
+// @param resp 请求的响应，未获取到响应时为nil
+// @param body 原始响应
+// @param err 底层错误
+func newAPIError(endpoint string, resp *http.Response, body []byte, err error) *APIError {
+	apiErr := &APIError{
+		Endpoint: endpoint,
+		Body:     body,
+		Err:      err,
+	}
+	if resp != nil {
+		apiErr.StatusCode = resp.StatusCode
+	}
+	return apiErr
+}
+
+// newRetError 根据微信返回的错误码创建APIError
+func newRetError(endpoint string, resp *http.Response, body []byte, ret int64, errMsg string) *APIError {
+	apiErr := newAPIError(endpoint, resp, body, nil)
+	apiErr.Ret, apiErr.ErrMsg = ret, errMsg
+	if apiErr.IsLoggedOut() {
+		apiErr.Err = ErrLogout
+	}
+	return apiErr
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	msg := e.Endpoint
+	if e.Ret != 0 {
+		msg += " respond error ret(" + strconv.FormatInt(e.Ret, 10) + ")"
+		if e.ErrMsg != "" {
+			msg += ": " + e.ErrMsg
+		}
+	} else if e.StatusCode != 0 && (e.StatusCode < 200 || e.StatusCode >= 300) {
+		msg += " respond status error: " + strconv.Itoa(e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Cause 返回底层错误，供errors.Cause使用
+func (e *APIError) Cause() error {
+	return e.Err
+}
+
+// Unwrap 返回底层错误，供标准库errors.Is与errors.As使用
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// IsLoggedOut 用户是否已经登出（在手机上登出或在其他地方登陆）
+func (e *APIError) IsLoggedOut() bool {
+	return e.Ret == RetLoggedOut || e.Ret == RetLoginElsewhere
+}
+
+// IsSessionInvalid 登陆会话是否已经失效，失效后需要重新登陆
+// 用户登出同样会导致会话失效
+func (e *APIError) IsSessionInvalid() bool {
+	return e.Ret == RetSessionInvalid || e.IsLoggedOut()
+}
+
+// IsRateLimited 是否因操作过于频繁被限制
+func (e *APIError) IsRateLimited() bool {
+	return e.Ret == RetRateLimited || e.StatusCode == http.StatusTooManyRequests
+}
+
+// AsAPIError 从错误链中找出APIError
+// @param err 要检查的错误，可以是被errors.Wrap包装过的APIError
+// @return apiErr 找到的最外层的APIError
+// @return ok 是否找到
+func AsAPIError(err error) (apiErr *APIError, ok bool) {
+	anyAPIError(err, func(e *APIError) bool {
+		apiErr, ok = e, true
+		return true
+	})
+	return
+}
+
+// anyAPIError 由外到内检查错误链中的APIError，任意一个满足条件即返回true
+func anyAPIError(err error, match func(apiErr *APIError) bool) bool {
+	for err != nil {
+		if apiErr, ok := err.(*APIError); ok && match(apiErr) {
+			return true
+		}
+		switch e := err.(type) {
+		case interface{ Cause() error }:
+			err = e.Cause()
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// IsLoggedOut 错误是否表示用户已经登出
+func IsLoggedOut(err error) bool {
+	return errors.Cause(err) == ErrLogout || anyAPIError(err, (*APIError).IsLoggedOut)
+}
+
+// IsSessionInvalid 错误是否表示登陆会话已经失效
+func IsSessionInvalid(err error) bool {
+	return errors.Cause(err) == ErrLogout || anyAPIError(err, (*APIError).IsSessionInvalid)
+}
+
+// IsRateLimited 错误是否表示操作过于频繁
+func IsRateLimited(err error) bool {
+	return anyAPIError(err, (*APIError).IsRateLimited)
+}
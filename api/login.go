@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"github.com/ikuiki/wwdk/conf"
@@ -9,52 +10,53 @@ import (
 	"github.com/ikuiki/wwdk/tool"
 	"github.com/pkg/errors"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 )
 
-// JsLogin 获取uuid
+// JsLoginContext 获取uuid
 // 初次打开时调用，获取uuid
 // @return uuid 获取到的uuid
-func (api *wechatwebAPI) JsLogin() (uuid string, body []byte, err error) {
+func (api *wechatwebAPI) JsLoginContext(ctx context.Context) (uuid string, body []byte, err error) {
 	params := url.Values{}
 	params.Set("appid", conf.AppID)
 	params.Set("fun", "new")
 	params.Set("lang", conf.Lang)
 	params.Set("_", tool.GetWxTimeStamp())
-	req, _ := http.NewRequest("GET", "https://login."+api.apiDomain+"/jslogin?"+params.Encode(), nil)
+	req, _ := api.newRequest(ctx, "GET", api.endpoint(EndpointLogin)+"/jslogin?"+params.Encode(), nil)
 	resp, err := api.request(req)
 	if err != nil {
-		return "", body, errors.New("request error: " + err.Error())
+		return "", body, newAPIError("jslogin", nil, nil, errors.Wrap(err, "request error"))
 	}
 	defer resp.Body.Close()
 	body, _ = ioutil.ReadAll(resp.Body)
 	ret := tool.ExtractWxWindowRespond(string(body))
 	if ret["window.QRLogin.code"] != "200" {
-		return "", body, errors.New("window.QRLogin.code = " + ret["window.QRLogin.code"])
+		return "", body, newAPIError("jslogin", resp, body, errors.New("window.QRLogin.code = "+ret["window.QRLogin.code"]))
 	}
 	uuid = ret["window.QRLogin.uuid"]
 	return uuid, body, nil
 }
 
-// Login 等待用户扫码登陆
+// LoginContext 等待用户扫码登陆
 // 获取uuid后就可以生成二维码等用户扫码了
 // @param uuid getUuid接口获取到的uuid
 // @param tip tip参数，一般第一轮为1，第二轮开始就为0
 // @return code 返回的windows.code，状态码
 // @return userAvatar 当用户扫码后返回用户头像
 // @return redirectURL 用户确认登陆后返回重定向地址
-func (api *wechatwebAPI) Login(uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error) {
+func (api *wechatwebAPI) LoginContext(ctx context.Context, uuid, tip string) (code, userAvatar, redirectURL string, body []byte, err error) {
 	params := url.Values{}
 	params.Set("loginicon", "true")
 	params.Set("tip", tip)
 	params.Set("uuid", uuid)
 	// params.Set("r", strconv.FormatInt(^(time.Now().Unix()), 10))
 	params.Set("_", tool.GetWxTimeStamp())
-	req, _ := http.NewRequest(`GET`, "https://login."+api.apiDomain+"/cgi-bin/mmwebwx-bin/login?"+params.Encode(), nil)
+	req, _ := api.newRequest(ctx, `GET`, api.endpoint(EndpointLogin)+"/cgi-bin/mmwebwx-bin/login?"+params.Encode(), nil)
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.Errorf("waitForScan request error: %v", err)
+		err = newAPIError("login", nil, nil, errors.Wrap(err, "waitForScan request error"))
 		return
 	}
 	defer resp.Body.Close()
@@ -69,25 +71,64 @@ func (api *wechatwebAPI) Login(uuid, tip string) (code, userAvatar, redirectURL
 	return
 }
 
-// WebwxNewLoginPage 获取登陆凭据
+// PushLoginContext 推送登陆
+// 使用上次登陆的Wxuin向手机推送登陆确认，用户在手机上确认后即可登陆，无需扫码
+// 推送成功后使用返回的uuid调用Login等待用户确认，后续流程与扫码登陆相同
+// 没有上次登陆的Wxuin时返回ErrNoUin
+// @return uuid 用于等待用户确认的uuid
+func (api *wechatwebAPI) PushLoginContext(ctx context.Context) (uuid string, body []byte, err error) {
+	if api.loginInfo.Wxuin == "" {
+		return "", nil, newAPIError("webwxpushloginurl", nil, nil, ErrNoUin)
+	}
+	params := url.Values{}
+	params.Set("uin", api.loginInfo.Wxuin)
+	req, _ := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxpushloginurl?"+params.Encode(), nil)
+	resp, err := api.request(req)
+	if err != nil {
+		return "", nil, newAPIError("webwxpushloginurl", nil, nil, errors.Wrap(err, "request error"))
+	}
+	defer resp.Body.Close()
+	body, _ = ioutil.ReadAll(resp.Body)
+	var pushResp datastruct.PushLoginRespond
+	err = json.Unmarshal(body, &pushResp)
+	if err != nil {
+		return "", body, newAPIError("webwxpushloginurl", resp, body, errors.Wrap(err, "Unmarshal respond json fail"))
+	}
+	if pushResp.Ret != "0" {
+		ret, _ := strconv.ParseInt(pushResp.Ret, 10, 64)
+		return "", body, api.newRetError("webwxpushloginurl", resp, body, ret, pushResp.Msg)
+	}
+	if pushResp.UUID == "" {
+		return "", body, newAPIError("webwxpushloginurl", resp, body, errors.New("respond empty uuid"))
+	}
+	return pushResp.UUID, body, nil
+}
+
+// WebwxNewLoginPageContext 获取登陆凭据
 // 用户扫码确认登陆后，获取登陆凭据
 // @param redirectURL 当用户扫码确认登陆后获取到的redirectURL
-func (api *wechatwebAPI) WebwxNewLoginPage(redirectURL string) (body []byte, err error) {
-	req, _ := http.NewRequest(`GET`, redirectURL+"&fun=new", nil) // 统一不加version=v2了
+func (api *wechatwebAPI) WebwxNewLoginPageContext(ctx context.Context, redirectURL string) (body []byte, err error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		err = newAPIError("webwxnewloginpage", nil, nil, errors.Wrap(err, "parse redirectURL error"))
+		return
+	}
+	// 通过地址解析器重新拼接地址，使其与其他api请求指向同一个服务器
+	req, _ := api.newRequest(ctx, `GET`, api.endpoint(EndpointAPI)+u.RequestURI()+"&fun=new", nil) // 统一不加version=v2了
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("getCookie request error: " + err.Error())
+		err = newAPIError("webwxnewloginpage", nil, nil, errors.Wrap(err, "getCookie request error"))
 		return
 	}
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.New("Read respond body error: " + err.Error())
+		err = newAPIError("webwxnewloginpage", resp, body, errors.Wrap(err, "Read respond body error"))
 		return
 	}
 	var bodyResp datastruct.GetCookieRespond
 	err = xml.Unmarshal(body, &bodyResp)
 	if err != nil {
-		err = errors.New("Unmarshal respond xml error: " + err.Error())
+		err = newAPIError("webwxnewloginpage", resp, body, errors.Wrap(err, "Unmarshal respond xml error"))
 		return
 	}
 	// 此处获取到skey与passTicket
@@ -96,16 +137,16 @@ func (api *wechatwebAPI) WebwxNewLoginPage(redirectURL string) (body []byte, err
 	return
 }
 
-// WebwxInit 初始化微信
+// WebwxInitContext 初始化微信
 // 当获取到登陆凭证后，即可调用此接口初始化微信获取基本信息
 // @return user 当前登陆的用户的信息
 // @return contactList 部分联系人列表⚠️此列表不全，要和后面获取联系人的列表合并，切记切记
-func (api *wechatwebAPI) WebwxInit() (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error) {
+func (api *wechatwebAPI) WebwxInitContext(ctx context.Context) (user *datastruct.User, contactList []datastruct.Contact, body []byte, err error) {
 	reqBody, err := json.Marshal(datastruct.WxInitRequestBody{
 		BaseRequest: api.baseRequest(),
 	})
 	if err != nil {
-		err = errors.New("json.Marshal error: " + err.Error())
+		err = newAPIError("webwxinit", nil, nil, errors.Wrap(err, "json.Marshal error"))
 		return
 	}
 	params := url.Values{}
@@ -116,16 +157,16 @@ func (api *wechatwebAPI) WebwxInit() (user *datastruct.User, contactList []datas
 	// 	"application/json;charset=UTF-8",
 	// 	bytes.NewReader(reqBody))
 
-	req, err := http.NewRequest("POST",
-		"https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxinit?"+params.Encode(),
+	req, err := api.newRequest(ctx, "POST",
+		api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxinit?"+params.Encode(),
 		bytes.NewReader(reqBody))
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxinit", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("do request error: " + err.Error())
+		err = newAPIError("webwxinit", nil, nil, errors.Wrap(err, "do request error"))
 		return
 	}
 	defer resp.Body.Close()
@@ -134,11 +175,11 @@ func (api *wechatwebAPI) WebwxInit() (user *datastruct.User, contactList []datas
 	body, _ = ioutil.ReadAll(resp.Body)
 	err = json.Unmarshal(body, &respStruct)
 	if err != nil {
-		err = errors.New("respond json Unmarshal to struct fail: " + err.Error())
+		err = newAPIError("webwxinit", resp, body, errors.Wrap(err, "respond json Unmarshal to struct fail"))
 		return
 	}
 	if respStruct.BaseResponse.Ret != 0 {
-		err = errors.Errorf("respond ret(%d) error: %s", respStruct.BaseResponse.Ret, respStruct.BaseResponse.ErrMsg)
+		err = api.newRetError("webwxinit", resp, body, respStruct.BaseResponse.Ret, respStruct.BaseResponse.ErrMsg)
 		return
 	}
 	user = respStruct.User
@@ -148,8 +189,8 @@ func (api *wechatwebAPI) WebwxInit() (user *datastruct.User, contactList []datas
 	return
 }
 
-// Logout 退出登录
-func (api *wechatwebAPI) Logout() (body []byte, err error) {
+// LogoutContext 退出登录
+func (api *wechatwebAPI) LogoutContext(ctx context.Context) (body []byte, err error) {
 	params := url.Values{}
 	params.Set("redirect", "0")
 	params.Set("type", "1")
@@ -157,15 +198,21 @@ func (api *wechatwebAPI) Logout() (body []byte, err error) {
 	form := url.Values{}
 	form.Set("sid", api.loginInfo.Wxsid)
 	form.Set("uin", api.loginInfo.Wxuin)
-	resp, err := api.client.PostForm("https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxlogout?"+params.Encode(), form)
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxlogout?"+params.Encode(), strings.NewReader(form.Encode()))
+	if err != nil {
+		err = newAPIError("webwxlogout", nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := api.client.Do(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxlogout", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxlogout", resp, body, errors.Wrap(err, "request error"))
 		return
 	}
 	return
@@ -20,6 +20,23 @@ type wechatwebAPIMarshalData struct {
 	CookieMap map[string][]*http.Cookie
 }
 
+// cookieURLs 需要持久化cookie的地址，key为host
+func (api *wechatwebAPI) cookieURLs() (urls map[string]*url.URL) {
+	urls = make(map[string]*url.URL)
+	for _, endpoint := range []Endpoint{
+		EndpointAPI,
+		EndpointWebpush,
+		EndpointFile,
+		// EndpointLogin,
+	} {
+		u, _ := url.Parse(api.endpoint(endpoint))
+		urls[u.Host] = u
+	}
+	u, _ := url.Parse("https://.qq.com")
+	urls[u.Host] = u
+	return
+}
+
 func (api *wechatwebAPI) SetLoginModifyNotifyChan(notifyChan chan<- bool) {
 	api.loginModifyNotifyChan = notifyChan
 }
@@ -27,14 +44,7 @@ func (api *wechatwebAPI) SetLoginModifyNotifyChan(notifyChan chan<- bool) {
 func (api *wechatwebAPI) Marshal() (data []byte, err error) {
 	// 储存cookie
 	cookieMap := make(map[string][]*http.Cookie)
-	for _, host := range []string{
-		api.apiDomain,
-		"webpush." + api.apiDomain,
-		"file." + api.apiDomain,
-		// "login." + api.apiDomain,
-		".qq.com",
-	} {
-		u, _ := url.Parse("https://" + host)
+	for host, u := range api.cookieURLs() {
 		cookieMap[host] = api.client.Jar.Cookies(u)
 	}
 	data, err = json.Marshal(wechatwebAPIMarshalData{
@@ -62,14 +72,7 @@ func (api *wechatwebAPI) Unmarshal(data []byte) (err error) {
 		api.apiDomain = dataStruct.APIDomain
 		api.deviceID = dataStruct.DeviceID
 		api.loginInfo = dataStruct.LoginInfo
-		for _, host := range []string{
-			api.apiDomain,
-			"webpush." + api.apiDomain,
-			"file." + api.apiDomain,
-			// "login." + api.apiDomain,
-			".qq.com",
-		} {
-			u, _ := url.Parse("https://" + host)
+		for host, u := range api.cookieURLs() {
 			api.client.Jar.SetCookies(u, dataStruct.CookieMap[host])
 		}
 	}
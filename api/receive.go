@@ -1,154 +1,204 @@
 package api
 
 import (
+	"context"
 	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 )
 
-// SaveMessageImage 下载图片消息
-// 将消息的图片下载回来
-// @param msgID 要下载的图片消息的MsgID
-// @return imgData 下载到的图片的二进制数据
-func (api *wechatwebAPI) SaveMessageImage(msgID string) (imgData []byte, err error) {
-	params := url.Values{}
-	params.Set("MsgID", msgID)
-	params.Set("skey", api.loginInfo.SKey)
-	// params.Set("type", "slave")
-	req, err := http.NewRequest("GET", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxgetmsgimg?"+params.Encode(), nil)
+// MediaStream 媒体文件流
+// 下载媒体文件时不将内容读入内存，而是直接返回响应流，使用完毕后必须Close
+type MediaStream struct {
+	io.ReadCloser
+	// ContentLength 内容长度，未知时为-1
+	ContentLength int64
+	// ContentType 内容的mime类型
+	ContentType string
+}
+
+// openMediaStream 执行下载媒体文件的请求并返回响应流
+func (api *wechatwebAPI) openMediaStream(req *http.Request) (stream *MediaStream, err error) {
+	endpoint := path.Base(req.URL.Path)
+	resp, err := api.requestStream(req)
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError(endpoint, nil, nil, errors.Wrap(err, "do request error"))
 		return
 	}
-	// resp, err := api.client.Get("https://" + api.apiDomain + "/cgi-bin/mmwebwx-bin/webwxgetmsgimg?" + params.Encode())
-	// if err != nil {
-	// 	err = errors.New("request error: " + err.Error())
-	// 	return
-	// }
-	resp, err := api.request(req)
-	if err != nil {
-		err = errors.New("do request error: " + err.Error())
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		err = newAPIError(endpoint, resp, body, nil)
 		return
 	}
-	defer resp.Body.Close()
-	imgData, err = ioutil.ReadAll(resp.Body)
+	return &MediaStream{
+		ReadCloser:    resp.Body,
+		ContentLength: resp.ContentLength,
+		ContentType:   resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// readMediaStream 将媒体文件流读取完毕并关闭
+func readMediaStream(stream *MediaStream, err error) (data []byte, e error) {
 	if err != nil {
-		err = errors.New("Read io.ReadCloser error: " + err.Error())
-		return
+		return nil, err
+	}
+	defer stream.Close()
+	data, e = ioutil.ReadAll(stream)
+	if e != nil {
+		e = errors.New("Read io.ReadCloser error: " + e.Error())
 	}
 	return
 }
 
-// SaveMessageVoice 下载音频消息
-// 将消息的音频下载回来
-// @param msgID 要下载的音频消息的MsgID
-// @return imgData 下载到的音频的二进制数据
-func (api *wechatwebAPI) SaveMessageVoice(msgID string) (voiceData []byte, err error) {
+// StreamMessageImageContext 以流的方式下载图片消息
+// @param msgID 要下载的图片消息的MsgID
+// @return stream 图片内容的流，使用完毕后必须Close
+func (api *wechatwebAPI) StreamMessageImageContext(ctx context.Context, msgID string) (stream *MediaStream, err error) {
 	params := url.Values{}
 	params.Set("MsgID", msgID)
 	params.Set("skey", api.loginInfo.SKey)
-	req, err := http.NewRequest("GET", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxgetvoice?"+params.Encode(), nil)
-	if err != nil {
-		err = errors.New("create request error: " + err.Error())
-		return
-	}
-	req.Header.Set("Range", "bytes=0-")
-	// resp, err := api.client.Get("https://" + api.apiDomain + "/cgi-bin/mmwebwx-bin/webwxgetvoice?" + params.Encode())
-	// if err != nil {
-	// 	return "", errors.New("request error: " + err.Error())
-	// }
-	resp, err := api.request(req)
+	// params.Set("type", "slave")
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxgetmsgimg?"+params.Encode(), nil)
 	if err != nil {
-		err = errors.New("do request error: " + err.Error())
+		err = newAPIError("webwxgetmsgimg", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
-	defer resp.Body.Close()
-	voiceData, err = ioutil.ReadAll(resp.Body)
+	return api.openMediaStream(req)
+}
+
+// SaveMessageImageContext 下载图片消息
+// 将消息的图片下载回来
+// @param msgID 要下载的图片消息的MsgID
+// @return imgData 下载到的图片的二进制数据
+func (api *wechatwebAPI) SaveMessageImageContext(ctx context.Context, msgID string) (imgData []byte, err error) {
+	return readMediaStream(api.StreamMessageImageContext(ctx, msgID))
+}
+
+// StreamMessageVoiceContext 以流的方式下载音频消息
+// @param msgID 要下载的音频消息的MsgID
+// @return stream 音频内容的流，使用完毕后必须Close
+func (api *wechatwebAPI) StreamMessageVoiceContext(ctx context.Context, msgID string) (stream *MediaStream, err error) {
+	params := url.Values{}
+	params.Set("MsgID", msgID)
+	params.Set("skey", api.loginInfo.SKey)
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxgetvoice?"+params.Encode(), nil)
 	if err != nil {
-		err = errors.New("Read io.ReadCloser error: " + err.Error())
+		err = newAPIError("webwxgetvoice", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
-	return
+	req.Header.Set("Range", "bytes=0-")
+	return api.openMediaStream(req)
 }
 
-// SaveMessageVideo 下载视频消息
-// 将消息的视频下载回来
+// SaveMessageVoiceContext 下载音频消息
+// 将消息的音频下载回来
+// @param msgID 要下载的音频消息的MsgID
+// @return imgData 下载到的音频的二进制数据
+func (api *wechatwebAPI) SaveMessageVoiceContext(ctx context.Context, msgID string) (voiceData []byte, err error) {
+	return readMediaStream(api.StreamMessageVoiceContext(ctx, msgID))
+}
+
+// StreamMessageVideoContext 以流的方式下载视频消息
 // @param msgID 要下载的视频消息的MsgID
-// @return videoData 下载到的视频的二进制数据
-func (api *wechatwebAPI) SaveMessageVideo(msgID string) (videoData []byte, err error) {
+// @return stream 视频内容的流，使用完毕后必须Close
+func (api *wechatwebAPI) StreamMessageVideoContext(ctx context.Context, msgID string) (stream *MediaStream, err error) {
 	params := url.Values{}
 	params.Set("msgid", msgID)
 	params.Set("skey", api.loginInfo.SKey)
-	req, err := http.NewRequest("GET", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxgetvideo?"+params.Encode(), nil)
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxgetvideo?"+params.Encode(), nil)
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxgetvideo", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	req.Header.Set("Range", "bytes=0-")
-	resp, err := api.client.Do(req)
-	if err != nil {
-		err = errors.New("request error: " + err.Error())
-		return
-	}
-	defer resp.Body.Close()
-	videoData, err = ioutil.ReadAll(resp.Body)
+	return api.openMediaStream(req)
+}
+
+// SaveMessageVideoContext 下载视频消息
+// 将消息的视频下载回来
+// @param msgID 要下载的视频消息的MsgID
+// @return videoData 下载到的视频的二进制数据
+func (api *wechatwebAPI) SaveMessageVideoContext(ctx context.Context, msgID string) (videoData []byte, err error) {
+	return readMediaStream(api.StreamMessageVideoContext(ctx, msgID))
+}
+
+// StreamMessageFileContext 以流的方式下载文件消息的附件
+// @param mediaID 文件消息的MediaID
+// @param fileName 文件消息的FileName
+// @param fromUserName 发送这条消息的联系人（或群）的UserName
+// @return stream 附件内容的流，使用完毕后必须Close
+func (api *wechatwebAPI) StreamMessageFileContext(ctx context.Context, mediaID, fileName, fromUserName string) (stream *MediaStream, err error) {
+	params := url.Values{}
+	params.Set("sender", fromUserName)
+	params.Set("mediaid", mediaID)
+	params.Set("encryfilename", fileName)
+	params.Set("fromuser", api.loginInfo.Wxuin)
+	params.Set("pass_ticket", api.loginInfo.PassTicket)
+	params.Set("webwx_data_ticket", api.loginInfo.DataTicket)
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointFile)+"/cgi-bin/mmwebwx-bin/webwxgetmedia?"+params.Encode(), nil)
 	if err != nil {
-		err = errors.New("Read io.ReadCloser error: " + err.Error())
+		err = newAPIError("webwxgetmedia", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
-	return
+	return api.openMediaStream(req)
 }
 
-// SaveContactImg 保存联系人头像
-// 根据头像地址保存头像
+// SaveMessageFileContext 下载文件消息的附件
+// 将文件消息(MsgType=49,AppMsgType=6)的附件下载回来
+// @param mediaID 文件消息的MediaID
+// @param fileName 文件消息的FileName
+// @param fromUserName 发送这条消息的联系人（或群）的UserName
+// @return fileData 下载到的文件的二进制数据
+func (api *wechatwebAPI) SaveMessageFileContext(ctx context.Context, mediaID, fileName, fromUserName string) (fileData []byte, err error) {
+	return readMediaStream(api.StreamMessageFileContext(ctx, mediaID, fileName, fromUserName))
+}
+
+// StreamContactImgContext 以流的方式下载联系人头像
 // @param headImgURL 联系人头像地址
-// @return imgData 下载的头像的二进制图片数据
-func (api *wechatwebAPI) SaveContactImg(headImgURL string) (imgData []byte, err error) {
+// @return stream 头像内容的流，使用完毕后必须Close
+func (api *wechatwebAPI) StreamContactImgContext(ctx context.Context, headImgURL string) (stream *MediaStream, err error) {
 	// 貌似时不需要带skey的，不如做个判断好了
 	if strings.HasSuffix(headImgURL, "&skey=") {
 		headImgURL = headImgURL + api.loginInfo.SKey
 	}
-	req, err := http.NewRequest("GET", "https://"+api.apiDomain+headImgURL, nil)
-	if err != nil {
-		err = errors.New("create request error: " + err.Error())
-		return
-	}
-	resp, err := api.client.Do(req)
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+headImgURL, nil)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxgeticon", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
-	defer resp.Body.Close()
-	imgData, err = ioutil.ReadAll(resp.Body)
+	return api.openMediaStream(req)
+}
+
+// SaveContactImgContext 保存联系人头像
+// 根据头像地址保存头像
+// @param headImgURL 联系人头像地址
+// @return imgData 下载的头像的二进制图片数据
+func (api *wechatwebAPI) SaveContactImgContext(ctx context.Context, headImgURL string) (imgData []byte, err error) {
+	return readMediaStream(api.StreamContactImgContext(ctx, headImgURL))
+}
+
+// StreamMemberImgContext 以流的方式下载群成员的头像
+// @param userName 群成员的UserName
+// @param chatroomID 群的EncryChatRoomId
+// @return stream 头像内容的流，使用完毕后必须Close
+func (api *wechatwebAPI) StreamMemberImgContext(ctx context.Context, userName, chatroomID string) (stream *MediaStream, err error) {
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxgeticon?seq=0&username="+userName+"&chatroomid="+chatroomID+"&skey=", nil)
 	if err != nil {
-		err = errors.New("Read io.ReadCloser error: " + err.Error())
+		err = newAPIError("webwxgeticon", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
-	return
+	return api.openMediaStream(req)
 }
 
-// SaveMemberImg 保存群成员的头像
+// SaveMemberImgContext 保存群成员的头像
 // @param userName 群成员的UserName
 // @param chatroomID 群的EncryChatRoomId
 // @return imgData 下载的头像的二进制图片数据
-func (api *wechatwebAPI) SaveMemberImg(userName, chatroomID string) (imgData []byte, err error) {
-	req, err := http.NewRequest("GET", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxgeticon?seq=0&username="+userName+"&chatroomid="+chatroomID+"&skey=", nil)
-	if err != nil {
-		err = errors.New("create request error: " + err.Error())
-		return
-	}
-	resp, err := api.client.Do(req)
-	if err != nil {
-		err = errors.New("request error: " + err.Error())
-		return
-	}
-	defer resp.Body.Close()
-	imgData, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		err = errors.New("Read io.ReadCloser error: " + err.Error())
-		return
-	}
-	return
+func (api *wechatwebAPI) SaveMemberImgContext(ctx context.Context, userName, chatroomID string) (imgData []byte, err error) {
+	return readMediaStream(api.StreamMemberImgContext(ctx, userName, chatroomID))
 }
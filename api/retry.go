@@ -0,0 +1,212 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrCircuitOpen 错误：接口因操作过于频繁被熔断，熔断期间的请求不会发送到服务器
+	ErrCircuitOpen = errors.New("circuit open")
+)
+
+// RetryPolicy 请求重试策略
+// 作为配置传入NewWechatwebAPI，未传入时使用DefaultRetryPolicy
+type RetryPolicy struct {
+	// MaxAttempts 最大尝试次数（包含第一次请求），小于等于1时不重试
+	MaxAttempts int
+	// BaseDelay 第一次重试前的等待时间，之后每次重试翻倍
+	BaseDelay time.Duration
+	// MaxDelay 重试等待时间的上限
+	MaxDelay time.Duration
+	// Jitter 等待时间的随机抖动比例，取值0~1，如0.2表示在等待时间的80%~100%之间随机
+	Jitter float64
+	// IdempotentEndpoints 幂等的接口（如synccheck），网络错误或服务器5xx时可以安全重试
+	// 其余接口只有在连接服务器失败（请求一定未发出）时才会重试
+	// 注意webwxsync不是幂等的：服务器处理请求后即推进SyncKey，响应丢失后重试会丢掉其中的消息
+	IdempotentEndpoints []string
+	// BreakerCooldown 接口返回操作频繁后的熔断时长，熔断期间该接口的请求直接返回错误，为0时不熔断
+	BreakerCooldown time.Duration
+	// OnAttempt 每次请求失败时回调，可用于日志与统计
+	OnAttempt func(attempt RetryAttempt)
+	// OnCircuitBreak 接口被熔断时回调
+	OnCircuitBreak func(endpoint string, cooldown time.Duration)
+}
+
+// RetryAttempt 一次失败的请求
+type RetryAttempt struct {
+	Endpoint   string        // 请求的接口
+	Attempt    int           // 第几次尝试，从1开始
+	StatusCode int           // HTTP状态码，未获取到响应时为0
+	Err        error         // 请求错误，服务器返回5xx时为nil
+	Retrying   bool          // 是否还会继续重试
+	Delay      time.Duration // 下次重试前的等待时间
+}
+
+// DefaultRetryPolicy 默认的重试策略
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+		Jitter:      0.2,
+		IdempotentEndpoints: []string{
+			"jslogin", "login", "webwxinit", "webwxgetcontact", "webwxbatchgetcontact",
+			"synccheck", "webwxstatusnotify", "webwxoplog",
+			"webwxgetmsgimg", "webwxgetvoice", "webwxgetvideo", "webwxgetmedia",
+			"webwxgeticon", "webwxgetheadimg",
+		},
+		BreakerCooldown: 1 * time.Minute,
+	}
+}
+
+// Backoff 计算第attempt次失败后重试前的等待时间
+// @param attempt 失败的次数，从1开始
+func (p *RetryPolicy) Backoff(attempt int) (delay time.Duration) {
+	delay = p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// isIdempotent 接口是否幂等
+func (p *RetryPolicy) isIdempotent(endpoint string) bool {
+	for _, e := range p.IdempotentEndpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry 判断请求失败后是否可以重试
+func (p *RetryPolicy) shouldRetry(req *http.Request, endpoint string, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		// 请求已被取消
+		return false
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// 请求体无法重放
+		return false
+	}
+	if err != nil {
+		return isDialError(err) || p.isIdempotent(endpoint)
+	}
+	return resp.StatusCode >= 500 && p.isIdempotent(endpoint)
+}
+
+// isDialError 是否为连接服务器时发生的错误，此时请求一定未发送到服务器
+func isDialError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+// circuitBreaker 按接口熔断
+type circuitBreaker struct {
+	mu        sync.Mutex
+	openUntil map[string]time.Time
+}
+
+// allow 接口当前是否允许请求
+func (b *circuitBreaker) allow(endpoint string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil[endpoint])
+}
+
+// trip 熔断接口
+func (b *circuitBreaker) trip(endpoint string, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil == nil {
+		b.openUntil = make(map[string]time.Time)
+	}
+	b.openUntil[endpoint] = time.Now().Add(cooldown)
+}
+
+// tripIfRateLimited 如果错误为操作频繁则熔断对应接口
+func (api *wechatwebAPI) tripIfRateLimited(apiErr *APIError) {
+	if !apiErr.IsRateLimited() || api.retryPolicy.BreakerCooldown <= 0 {
+		return
+	}
+	api.breaker.trip(apiErr.Endpoint, api.retryPolicy.BreakerCooldown)
+	if api.retryPolicy.OnCircuitBreak != nil {
+		api.retryPolicy.OnCircuitBreak(apiErr.Endpoint, api.retryPolicy.BreakerCooldown)
+	}
+}
+
+// newRetError 根据微信返回的错误码创建APIError，如果为操作频繁则熔断该接口
+func (api *wechatwebAPI) newRetError(endpoint string, resp *http.Response, body []byte, ret int64, errMsg string) *APIError {
+	apiErr := newRetError(endpoint, resp, body, ret, errMsg)
+	api.tripIfRateLimited(apiErr)
+	return apiErr
+}
+
+// requestWithRetry 按照重试策略执行请求
+func (api *wechatwebAPI) requestWithRetry(client *http.Client, req *http.Request, endpoint string) (resp *http.Response, err error) {
+	if !api.breaker.allow(endpoint) {
+		return nil, &APIError{Endpoint: endpoint, Ret: RetRateLimited, Err: ErrCircuitOpen}
+	}
+	policy := api.retryPolicy
+	for attempt := 1; ; attempt++ {
+		resp, err = api.doRequest(client, req)
+		if err == nil && resp.StatusCode < 500 {
+			break
+		}
+		retrying := attempt < policy.MaxAttempts && policy.shouldRetry(req, endpoint, resp, err)
+		var delay time.Duration
+		if retrying {
+			delay = policy.Backoff(attempt)
+		}
+		if policy.OnAttempt != nil {
+			a := RetryAttempt{Endpoint: endpoint, Attempt: attempt, Err: err, Retrying: retrying, Delay: delay}
+			if resp != nil {
+				a.StatusCode = resp.StatusCode
+			}
+			policy.OnAttempt(a)
+		}
+		if !retrying {
+			break
+		}
+		if resp != nil {
+			// 丢弃本次的响应，以便复用连接
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, errors.New("reset request body error: " + err.Error())
+			}
+		}
+	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		api.tripIfRateLimited(newAPIError(endpoint, resp, nil, nil))
+	}
+	return
+}
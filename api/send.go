@@ -2,21 +2,25 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/ikuiki/wwdk/datastruct"
 	"github.com/ikuiki/wwdk/tool"
 	"github.com/pkg/errors"
+	"html"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
-// StatusNotify 消息已读通知
+// StatusNotifyContext 消息已读通知
 // @param fromUserName 自己的UserName
 // @param toUserName 已读的联系人的UserName
 // @param code 用途未知，目前发现的时登陆完毕调用时此处填3（from和to的username都一样）其余时候都是1
-func (api *wechatwebAPI) StatusNotify(fromUserName, toUserName string, code int64) (body []byte, err error) {
+func (api *wechatwebAPI) StatusNotifyContext(ctx context.Context, fromUserName, toUserName string, code int64) (body []byte, err error) {
 	msgID, _ := strconv.ParseInt(tool.GetWxTimeStamp(), 10, 64)
 	data := datastruct.StatusNotifyRequest{
 		BaseRequest:  api.baseRequest(),
@@ -27,20 +31,20 @@ func (api *wechatwebAPI) StatusNotify(fromUserName, toUserName string, code int6
 	}
 	reqBody, err := json.Marshal(data)
 	if err != nil {
-		err = errors.New("Marshal request body to json fail: " + err.Error())
+		err = newAPIError("webwxstatusnotify", nil, nil, errors.Wrap(err, "Marshal request body to json fail"))
 		return
 	}
 	params := url.Values{}
 	params.Set("pass_ticket", api.loginInfo.PassTicket)
-	req, err := http.NewRequest("POST", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxstatusnotify?"+params.Encode(), bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxstatusnotify?"+params.Encode(), bytes.NewReader(reqBody))
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxstatusnotify", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxstatusnotify", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
@@ -48,23 +52,23 @@ func (api *wechatwebAPI) StatusNotify(fromUserName, toUserName string, code int6
 	var snResp datastruct.StatusNotifyRespond
 	err = json.Unmarshal(body, &snResp)
 	if err != nil {
-		err = errors.New("Unmarshal respond json fail: " + err.Error())
+		err = newAPIError("webwxstatusnotify", resp, body, errors.Wrap(err, "Unmarshal respond json fail"))
 		return
 	}
 	if snResp.BaseResponse.Ret != 0 {
-		err = errors.Errorf("respond error ret(%d): %s", snResp.BaseResponse.Ret, snResp.BaseResponse.ErrMsg)
+		err = api.newRetError("webwxstatusnotify", resp, body, snResp.BaseResponse.Ret, snResp.BaseResponse.ErrMsg)
 		return
 	}
 	return
 }
 
-// SendTextMessage 发送消息
+// SendTextMessageContext 发送消息
 // @param fromUserName 自己的UserName
 // @param toUserName 要发送的目标联系人的UserName
 // @param content 文字消息内容
 // @return MsgID 消息的服务器ID（发送后由服务器生成）
 // @return LocalID 消息本地ID（本地生成的）
-func (api *wechatwebAPI) SendTextMessage(fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error) {
+func (api *wechatwebAPI) SendTextMessageContext(ctx context.Context, fromUserName, toUserName, content string) (MsgID, LocalID string, body []byte, err error) {
 	msgReq := datastruct.SendMessageRequest{
 		BaseRequest: api.baseRequest(),
 		Msg: &datastruct.SendMessage{
@@ -78,46 +82,46 @@ func (api *wechatwebAPI) SendTextMessage(fromUserName, toUserName, content strin
 	}
 	reqBody, err := json.Marshal(msgReq)
 	if err != nil {
-		err = errors.New("Marshal reqBody to json fail: " + err.Error())
+		err = newAPIError("webwxsendmsg", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
 		return
 	}
 	params := url.Values{}
 	params.Set("pass_ticket", api.loginInfo.PassTicket)
-	req, err := http.NewRequest("POST", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxsendmsg?"+params.Encode(), bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxsendmsg?"+params.Encode(), bytes.NewReader(reqBody))
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxsendmsg", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxsendmsg", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
 	var smResp datastruct.SendMessageRespond
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.New("read response body error: " + err.Error())
+		err = newAPIError("webwxsendmsg", resp, body, errors.Wrap(err, "read response body error"))
 		return
 	}
 	err = json.Unmarshal(body, &smResp)
 	if err != nil {
-		err = errors.New("UnMarshal respond json fail: " + err.Error())
+		err = newAPIError("webwxsendmsg", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
 		return
 	}
 	if smResp.BaseResponse.Ret != 0 {
-		err = errors.Errorf("Respond error ret(%d): %s", smResp.BaseResponse.Ret, smResp.BaseResponse.ErrMsg)
+		err = api.newRetError("webwxsendmsg", resp, body, smResp.BaseResponse.Ret, smResp.BaseResponse.ErrMsg)
 		return
 	}
 	MsgID, LocalID = smResp.MsgID, smResp.LocalID
 	return
 }
 
-// SendRevokeMessage 撤回消息
+// SendRevokeMessageContext 撤回消息
 // @param toUserName 要发送的目标联系人的UserName
 // @param svrMsgID 消息的服务器ID（发送后由服务器生成）
 // @param clientMsgID 消息本地ID（本地生成的）
-func (api *wechatwebAPI) SendRevokeMessage(toUserName, svrMsgID, clientMsgID string) (body []byte, err error) {
+func (api *wechatwebAPI) SendRevokeMessageContext(ctx context.Context, toUserName, svrMsgID, clientMsgID string) (body []byte, err error) {
 	srmReq := datastruct.RevokeMessageRequest{
 		BaseRequest: api.baseRequest(),
 		ClientMsgID: clientMsgID,
@@ -126,34 +130,191 @@ func (api *wechatwebAPI) SendRevokeMessage(toUserName, svrMsgID, clientMsgID str
 	}
 	reqBody, err := json.Marshal(srmReq)
 	if err != nil {
-		err = errors.New("Marshal reqBody to json fail: " + err.Error())
+		err = newAPIError("webwxrevokemsg", nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
 		return
 	}
-	req, err := http.NewRequest("POST", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxrevokemsg", bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxrevokemsg", bytes.NewReader(reqBody))
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxrevokemsg", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxrevokemsg", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
 	var rmResp datastruct.RevokeMessageRespond
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.New("read response body error: " + err.Error())
+		err = newAPIError("webwxrevokemsg", resp, body, errors.Wrap(err, "read response body error"))
 		return
 	}
 	err = json.Unmarshal(body, &rmResp)
 	if err != nil {
-		err = errors.New("UnMarshal respond json fail: " + err.Error())
+		err = newAPIError("webwxrevokemsg", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
 		return
 	}
 	if rmResp.BaseResponse.Ret != 0 {
-		err = errors.Errorf("Respond error ret(%d): %s", rmResp.BaseResponse.Ret, rmResp.BaseResponse.ErrMsg)
+		err = api.newRetError("webwxrevokemsg", resp, body, rmResp.BaseResponse.Ret, rmResp.BaseResponse.ErrMsg)
 		return
 	}
 	return
 }
+
+// sendMediaMessage 发送带媒体的消息
+// 图片、视频、文件等消息的发送流程一致，仅接口地址与参数不同
+// @param apiPath 发送接口的路径
+// @param params 发送接口的url参数
+// @param msg 要发送的消息
+// @return MsgID 消息的服务器ID（发送后由服务器生成）
+// @return LocalID 消息本地ID（本地生成的）
+func (api *wechatwebAPI) sendMediaMessage(ctx context.Context, apiPath string, params url.Values, msg *datastruct.SendMessage) (MsgID, LocalID string, body []byte, err error) {
+	endpoint := path.Base(apiPath)
+	msgReq := datastruct.SendMessageRequest{
+		BaseRequest: api.baseRequest(),
+		Msg:         msg,
+	}
+	reqBody, err := json.Marshal(msgReq)
+	if err != nil {
+		err = newAPIError(endpoint, nil, nil, errors.Wrap(err, "Marshal reqBody to json fail"))
+		return
+	}
+	params.Set("pass_ticket", api.loginInfo.PassTicket)
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+apiPath+"?"+params.Encode(), bytes.NewReader(reqBody))
+	if err != nil {
+		err = newAPIError(endpoint, nil, nil, errors.Wrap(err, "create request error"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	resp, err := api.request(req)
+	if err != nil {
+		err = newAPIError(endpoint, nil, nil, errors.Wrap(err, "request error"))
+		return
+	}
+	defer resp.Body.Close()
+	var smResp datastruct.SendMessageRespond
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = newAPIError(endpoint, resp, body, errors.Wrap(err, "read response body error"))
+		return
+	}
+	err = json.Unmarshal(body, &smResp)
+	if err != nil {
+		err = newAPIError(endpoint, resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
+		return
+	}
+	if smResp.BaseResponse.Ret != 0 {
+		err = api.newRetError(endpoint, resp, body, smResp.BaseResponse.Ret, smResp.BaseResponse.ErrMsg)
+		return
+	}
+	MsgID, LocalID = smResp.MsgID, smResp.LocalID
+	return
+}
+
+// SendImageMessageContext 发送图片消息
+// 发送前需要先通过UploadMedia上传图片获取MediaID
+// @param fromUserName 自己的UserName
+// @param toUserName 要发送的目标联系人的UserName
+// @param mediaID 上传图片后获取到的MediaID
+// @return MsgID 消息的服务器ID（发送后由服务器生成）
+// @return LocalID 消息本地ID（本地生成的）
+func (api *wechatwebAPI) SendImageMessageContext(ctx context.Context, fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	params := url.Values{}
+	params.Set("fun", "async")
+	params.Set("f", "json")
+	localID := tool.GetWxTimeStamp()
+	return api.sendMediaMessage(ctx, "/cgi-bin/mmwebwx-bin/webwxsendmsgimg", params, &datastruct.SendMessage{
+		ClientMsgID:  localID,
+		FromUserName: fromUserName,
+		LocalID:      localID,
+		MediaID:      mediaID,
+		ToUserName:   toUserName,
+		Type:         datastruct.ImageMsg,
+	})
+}
+
+// SendVideoMessageContext 发送视频消息
+// 发送前需要先通过UploadMedia上传mp4视频获取MediaID
+// @param fromUserName 自己的UserName
+// @param toUserName 要发送的目标联系人的UserName
+// @param mediaID 上传视频后获取到的MediaID
+// @return MsgID 消息的服务器ID（发送后由服务器生成）
+// @return LocalID 消息本地ID（本地生成的）
+func (api *wechatwebAPI) SendVideoMessageContext(ctx context.Context, fromUserName, toUserName, mediaID string) (MsgID, LocalID string, body []byte, err error) {
+	params := url.Values{}
+	params.Set("fun", "async")
+	params.Set("f", "json")
+	localID := tool.GetWxTimeStamp()
+	return api.sendMediaMessage(ctx, "/cgi-bin/mmwebwx-bin/webwxsendvideomsg", params, &datastruct.SendMessage{
+		ClientMsgID:  localID,
+		FromUserName: fromUserName,
+		LocalID:      localID,
+		MediaID:      mediaID,
+		ToUserName:   toUserName,
+		Type:         datastruct.LittleVideoMsg,
+	})
+}
+
+// fileAppMsgAppID 发送文件时appmsg中填写的appid，为固定值
+const fileAppMsgAppID = "wxeb7ec651dd0aefa9"
+
+// SendFileMessageContext 发送文件消息
+// 发送前需要先通过UploadMedia上传文件获取MediaID，文件会以appmsg(type=6)的形式发送
+// @param fromUserName 自己的UserName
+// @param toUserName 要发送的目标联系人的UserName
+// @param mediaID 上传文件后获取到的MediaID
+// @param fileName 文件名
+// @param fileSize 文件大小
+// @return MsgID 消息的服务器ID（发送后由服务器生成）
+// @return LocalID 消息本地ID（本地生成的）
+func (api *wechatwebAPI) SendFileMessageContext(ctx context.Context, fromUserName, toUserName, mediaID, fileName string, fileSize int64) (MsgID, LocalID string, body []byte, err error) {
+	params := url.Values{}
+	params.Set("fun", "async")
+	params.Set("f", "json")
+	content := "<appmsg appid='" + fileAppMsgAppID + "' sdkver=''>" +
+		"<title>" + html.EscapeString(fileName) + "</title><des></des><action></action>" +
+		"<type>" + strconv.FormatInt(int64(datastruct.ReciveFileAppmsg), 10) + "</type>" +
+		"<content></content><url></url><lowurl></lowurl>" +
+		"<appattach><totallen>" + strconv.FormatInt(fileSize, 10) + "</totallen>" +
+		"<attachid>" + mediaID + "</attachid>" +
+		"<fileext>" + html.EscapeString(strings.TrimPrefix(filepath.Ext(fileName), ".")) + "</fileext></appattach>" +
+		"<extinfo></extinfo></appmsg>"
+	localID := tool.GetWxTimeStamp()
+	return api.sendMediaMessage(ctx, "/cgi-bin/mmwebwx-bin/webwxsendappmsg", params, &datastruct.SendMessage{
+		ClientMsgID:  localID,
+		Content:      content,
+		FromUserName: fromUserName,
+		LocalID:      localID,
+		ToUserName:   toUserName,
+		Type:         datastruct.MessageType(datastruct.ReciveFileAppmsg),
+	})
+}
+
+// SendEmoticonMessageContext 发送动图消息
+// mediaID与emoticonMd5二选一：发送新的gif需要先通过UploadMedia上传获取MediaID，转发收到过的动图则填写其md5即可
+// @param fromUserName 自己的UserName
+// @param toUserName 要发送的目标联系人的UserName
+// @param mediaID 上传动图后获取到的MediaID
+// @param emoticonMd5 收到过的动图的md5
+// @return MsgID 消息的服务器ID（发送后由服务器生成）
+// @return LocalID 消息本地ID（本地生成的）
+func (api *wechatwebAPI) SendEmoticonMessageContext(ctx context.Context, fromUserName, toUserName, mediaID, emoticonMd5 string) (MsgID, LocalID string, body []byte, err error) {
+	if mediaID == "" && emoticonMd5 == "" {
+		err = errors.New("either mediaID or emoticonMd5 is required")
+		return
+	}
+	params := url.Values{}
+	params.Set("fun", "sys")
+	localID := tool.GetWxTimeStamp()
+	return api.sendMediaMessage(ctx, "/cgi-bin/mmwebwx-bin/webwxsendemoticon", params, &datastruct.SendMessage{
+		ClientMsgID:  localID,
+		FromUserName: fromUserName,
+		LocalID:      localID,
+		MediaID:      mediaID,
+		ToUserName:   toUserName,
+		Type:         datastruct.AnimationEmotionsMsg,
+		EmojiFlag:    2,
+		EMoticonMd5:  emoticonMd5,
+	})
+}
@@ -2,26 +2,22 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/ikuiki/wwdk/datastruct"
 	"github.com/ikuiki/wwdk/tool"
 	"github.com/pkg/errors"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 )
 
-var (
-	// ErrLogout 错误：已经登出
-	ErrLogout = errors.New("Logout")
-)
-
-// SyncCheck 检查同步
+// SyncCheckContext 检查同步
 // 轮询微信服务器，如果有新的状态，会通过此接口返回需要同步的信息
 // @return retCode 状态码，正常为0
 // @return selector 状态机，根据此项判定是否需要拉去新信息
-func (api *wechatwebAPI) SyncCheck() (retCode, selector string, body []byte, err error) {
+func (api *wechatwebAPI) SyncCheckContext(ctx context.Context) (retCode, selector string, body []byte, err error) {
 	params := url.Values{}
 	params.Set("r", tool.GetWxTimeStamp())
 	params.Set("sid", api.loginInfo.Wxsid)
@@ -29,14 +25,14 @@ func (api *wechatwebAPI) SyncCheck() (retCode, selector string, body []byte, err
 	params.Set("deviceid", api.deviceID)
 	params.Set("synckey", tool.AssembleSyncKey(api.loginInfo.SyncKey))
 	params.Set("_", tool.GetWxTimeStamp())
-	req, err := http.NewRequest("GET", "https://webpush."+api.apiDomain+"/cgi-bin/mmwebwx-bin/synccheck?"+params.Encode(), nil)
+	req, err := api.newRequest(ctx, "GET", api.endpoint(EndpointWebpush)+"/cgi-bin/mmwebwx-bin/synccheck?"+params.Encode(), nil)
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("synccheck", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("synccheck", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
@@ -46,40 +42,119 @@ func (api *wechatwebAPI) SyncCheck() (retCode, selector string, body []byte, err
 	ret := tool.AnalysisSyncResp(retArr["window.synccheck"])
 	retCode, selector = ret.Retcode, ret.Selector
 	if retCode != "0" {
-		if retCode == "1101" {
-			err = ErrLogout
+		code, e := strconv.ParseInt(retCode, 10, 64)
+		if e != nil {
+			err = newAPIError("synccheck", resp, body, errors.New("respond Retcode "+retCode))
 			return
 		}
-		err = errors.New("respond Retcode " + retCode)
+		err = api.newRetError("synccheck", resp, body, code, "")
 		return
 	}
 	return
 }
 
-// WebwxSync 同步消息
+// maxSyncContinueRounds 一次同步中因ContinueFlag连续请求webwxsync的最大次数，防止服务器一直返回ContinueFlag导致死循环
+const maxSyncContinueRounds = 20
+
+// SyncResult 一次同步获取到的全部内容
+type SyncResult struct {
+	ModContacts        []datastruct.Contact                            // 有变更的联系人
+	DelContacts        []datastruct.WebwxSyncRespondDelContactListItem // 被删除的联系人
+	AddMessages        []datastruct.Message                            // 新消息
+	ModChatRoomMembers []datastruct.ModChatRoomMember                  // 有变更的群成员
+	Profile            *datastruct.Profile                             // 当前登陆用户的资料变更，没有变更时为nil
+}
+
+// WebwxSyncContext 同步消息
 // 如果检查同步接口返回有新消息需要同步，通过此接口从服务器中获取新消息
-// @return syncResp 同步状态返回值
-func (api *wechatwebAPI) WebwxSync() (modContacts []datastruct.Contact,
+// 服务器返回ContinueFlag时会继续请求，直到消息全部获取完毕，多次获取的结果按服务器返回的顺序合并
+// 后续的请求失败时同时返回已获取的结果与该错误，调用方需要先处理已获取的结果，剩余的消息会在下次同步时获取
+// 不需要群成员与当前用户资料的变更时可以使用此方法，否则请使用WebwxSyncDetailContext
+// @return modContacts 有变更的联系人
+// @return delContacts 被删除的联系人
+// @return addMessages 新消息
+// @return body 最后一次请求的原始响应
+func (api *wechatwebAPI) WebwxSyncContext(ctx context.Context) (modContacts []datastruct.Contact,
 	delContacts []datastruct.WebwxSyncRespondDelContactListItem,
 	addMessages []datastruct.Message,
 	body []byte, err error) {
-	syncResp := datastruct.WebwxSyncRespond{}
+	result, body, err := api.WebwxSyncDetailContext(ctx)
+	return result.ModContacts, result.DelContacts, result.AddMessages, body, err
+}
+
+// WebwxSyncDetailContext 同步消息，与WebwxSyncContext相同，但同时返回群成员与当前用户资料的变更
+// @return result 同步获取到的全部内容
+// @return body 最后一次请求的原始响应
+func (api *wechatwebAPI) WebwxSyncDetailContext(ctx context.Context) (result SyncResult, body []byte, err error) {
+	for round := 0; round < maxSyncContinueRounds; round++ {
+		syncResp, respBody, e := api.webwxSyncOnce(ctx)
+		if e != nil {
+			// 之前的请求已推进了SyncKey，已获取的结果必须与错误一同返回，否则其中的消息会丢失
+			body, err = respBody, e
+			return
+		}
+		body = respBody
+		result.ModContacts, result.DelContacts = mergeSyncContacts(result.ModContacts, result.DelContacts, syncResp.ModContactList, syncResp.DelContactList)
+		result.AddMessages = append(result.AddMessages, syncResp.AddMsgList...)
+		result.ModChatRoomMembers = append(result.ModChatRoomMembers, syncResp.ModChatRoomMemberList...)
+		if syncResp.Profile != nil && syncResp.Profile.BitFlag != 0 {
+			result.Profile = syncResp.Profile
+		}
+		if syncResp.ContinueFlag == 0 {
+			break
+		}
+	}
+	return
+}
+
+// mergeSyncContacts 合并多次同步获取到的联系人变更
+// 后获取到的变更覆盖先获取到的：先删除后修改的联系人视为修改，先修改后删除的视为删除
+func mergeSyncContacts(modContacts []datastruct.Contact, delContacts []datastruct.WebwxSyncRespondDelContactListItem,
+	newModContacts []datastruct.Contact, newDelContacts []datastruct.WebwxSyncRespondDelContactListItem) (
+	[]datastruct.Contact, []datastruct.WebwxSyncRespondDelContactListItem) {
+	if len(modContacts) == 0 && len(delContacts) == 0 {
+		return newModContacts, newDelContacts
+	}
+	changed := make(map[string]bool)
+	for _, contact := range newModContacts {
+		changed[contact.UserName] = true
+	}
+	for _, contact := range newDelContacts {
+		changed[contact.UserName] = true
+	}
+	var mergedMod []datastruct.Contact
+	for _, contact := range modContacts {
+		if !changed[contact.UserName] {
+			mergedMod = append(mergedMod, contact)
+		}
+	}
+	var mergedDel []datastruct.WebwxSyncRespondDelContactListItem
+	for _, contact := range delContacts {
+		if !changed[contact.UserName] {
+			mergedDel = append(mergedDel, contact)
+		}
+	}
+	return append(mergedMod, newModContacts...), append(mergedDel, newDelContacts...)
+}
+
+// webwxSyncOnce 请求一次webwxsync并更新SyncKey
+func (api *wechatwebAPI) webwxSyncOnce(ctx context.Context) (syncResp datastruct.WebwxSyncRespond, body []byte, err error) {
 	reqBody, err := json.Marshal(datastruct.WebwxSyncRequest{
 		BaseRequest: api.baseRequest(),
 		SyncKey:     api.loginInfo.SyncKey,
 		Rr:          ^time.Now().Unix() + 1,
 	})
 	if err != nil {
-		err = errors.New("Marshal request body to json fail: " + err.Error())
+		err = newAPIError("webwxsync", nil, nil, errors.Wrap(err, "Marshal request body to json fail"))
 		return
 	}
 	params := url.Values{}
 	params.Set("sid", api.loginInfo.Wxsid)
 	params.Set("skey", api.loginInfo.SKey)
 	// params.Set("pass_ticket", api.PassTicket)
-	req, err := http.NewRequest("POST", "https://"+api.apiDomain+"/cgi-bin/mmwebwx-bin/webwxsync?"+params.Encode(), bytes.NewReader(reqBody))
+	req, err := api.newRequest(ctx, "POST", api.endpoint(EndpointAPI)+"/cgi-bin/mmwebwx-bin/webwxsync?"+params.Encode(), bytes.NewReader(reqBody))
 	if err != nil {
-		err = errors.New("create request error: " + err.Error())
+		err = newAPIError("webwxsync", nil, nil, errors.Wrap(err, "create request error"))
 		return
 	}
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
@@ -91,14 +166,14 @@ func (api *wechatwebAPI) WebwxSync() (modContacts []datastruct.Contact,
 	// }
 	resp, err := api.request(req)
 	if err != nil {
-		err = errors.New("request error: " + err.Error())
+		err = newAPIError("webwxsync", nil, nil, errors.Wrap(err, "request error"))
 		return
 	}
 	defer resp.Body.Close()
 	body, _ = ioutil.ReadAll(resp.Body)
 	err = json.Unmarshal(body, &syncResp)
 	if err != nil {
-		err = errors.New("Unmarshal respond json fail: " + err.Error())
+		err = newAPIError("webwxsync", resp, body, errors.Wrap(err, "Unmarshal respond json fail"))
 		return
 	}
 	// 更新SyncKey
@@ -108,10 +183,8 @@ func (api *wechatwebAPI) WebwxSync() (modContacts []datastruct.Contact,
 		api.loginInfo.SyncKey = syncResp.SyncKey
 	}
 	if syncResp.BaseResponse.Ret != 0 {
-		err = errors.Errorf("respond error ret(%d): %s", syncResp.BaseResponse.Ret, syncResp.BaseResponse.ErrMsg)
+		err = api.newRetError("webwxsync", resp, body, syncResp.BaseResponse.Ret, syncResp.BaseResponse.ErrMsg)
 		return
 	}
-	// 赋值结果
-	modContacts, delContacts, addMessages = syncResp.ModContactList, syncResp.DelContactList, syncResp.AddMsgList
 	return
 }
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"time"
+)
+
+// DefaultTimeout 单次请求的默认超时时间
+const DefaultTimeout = 1 * time.Minute
+
+// Timeout 单次请求（包括读取响应体）的超时时间，作为配置传入NewWechatwebAPI后生效，不传入时为DefaultTimeout
+// 小于等于0时不限制，此时只能通过各个请求的context控制超时
+// 以流的方式下载媒体文件（Stream*与Save*）的请求不受此限制，其时间由context控制
+type Timeout time.Duration
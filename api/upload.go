@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/ikuiki/wwdk/tool"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// uploadChunkSize 上传文件时每个分片的大小，与网页版保持一致为512K
+const uploadChunkSize = 512 * 1024
+
+// getUploadMediaType 根据文件名获取上传时需要的type(mime类型)与mediatype字段
+func getUploadMediaType(fileName string) (mimeType, mediaType string) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	mimeType = mime.TypeByExtension("." + ext)
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	// 去掉mime类型中的charset等附加参数
+	if index := strings.Index(mimeType, ";"); index > 0 {
+		mimeType = mimeType[:index]
+	}
+	switch ext {
+	case "png", "jpg", "jpeg", "bmp":
+		mediaType = "pic"
+	case "mp4":
+		mediaType = "video"
+	default:
+		// gif也是作为doc上传的
+		mediaType = "doc"
+	}
+	return
+}
+
+// UploadMediaContext 上传文件
+// 发送图片、视频、文件前都需要先上传文件获取MediaID，文件大于512K时会分片上传
+// @param fromUserName 自己的UserName
+// @param toUserName 要发送的目标联系人的UserName
+// @param fileName 文件名，会根据文件名后缀判断文件类型
+// @param file 文件内容
+// @return mediaID 上传后服务器返回的MediaID
+func (api *wechatwebAPI) UploadMediaContext(ctx context.Context, fromUserName, toUserName, fileName string, file io.ReadSeeker) (mediaID string, body []byte, err error) {
+	// 先读取一遍文件计算大小与md5
+	hash := md5.New()
+	totalLen, err := io.Copy(hash, file)
+	if err != nil {
+		err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(err, "read file error"))
+		return
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(err, "seek file error"))
+		return
+	}
+	mimeType, mediaType := getUploadMediaType(fileName)
+	clientMediaID, _ := strconv.ParseInt(tool.GetWxTimeStamp(), 10, 64)
+	uploadMediaRequest, err := json.Marshal(datastruct.UploadMediaRequest{
+		UploadType:    2,
+		BaseRequest:   api.baseRequest(),
+		ClientMediaID: clientMediaID,
+		TotalLen:      totalLen,
+		StartPos:      0,
+		DataLen:       totalLen,
+		MediaType:     4,
+		FromUserName:  fromUserName,
+		ToUserName:    toUserName,
+		FileMd5:       hex.EncodeToString(hash.Sum(nil)),
+	})
+	if err != nil {
+		err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(err, "Marshal uploadmediarequest to json fail"))
+		return
+	}
+	// 并发上传时需要原子操作，保证文件id不重复
+	fileID := "WU_FILE_" + strconv.FormatInt(atomic.AddInt64(&api.uploadCount, 1)-1, 10)
+	chunks := (totalLen + uploadChunkSize - 1) / uploadChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	params := url.Values{}
+	params.Set("f", "json")
+	chunkData := make([]byte, uploadChunkSize)
+	for chunk := int64(0); chunk < chunks; chunk++ {
+		n, e := io.ReadFull(file, chunkData)
+		if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+			err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(e, "read file chunk error"))
+			return
+		}
+		// 组装multipart表单
+		reqBody := &bytes.Buffer{}
+		writer := multipart.NewWriter(reqBody)
+		writer.WriteField("id", fileID)
+		writer.WriteField("name", fileName)
+		writer.WriteField("type", mimeType)
+		writer.WriteField("lastModifiedDate", time.Now().Format("Mon Jan 02 2006 15:04:05 GMT-0700 (MST)"))
+		writer.WriteField("size", strconv.FormatInt(totalLen, 10))
+		if chunks > 1 {
+			writer.WriteField("chunks", strconv.FormatInt(chunks, 10))
+			writer.WriteField("chunk", strconv.FormatInt(chunk, 10))
+		}
+		writer.WriteField("mediatype", mediaType)
+		writer.WriteField("uploadmediarequest", string(uploadMediaRequest))
+		writer.WriteField("webwx_data_ticket", api.loginInfo.DataTicket)
+		writer.WriteField("pass_ticket", api.loginInfo.PassTicket)
+		part, e := writer.CreateFormFile("filename", fileName)
+		if e != nil {
+			err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(e, "create form file error"))
+			return
+		}
+		part.Write(chunkData[:n])
+		err = writer.Close()
+		if err != nil {
+			err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(err, "close multipart writer error"))
+			return
+		}
+		req, e := api.newRequest(ctx, "POST", api.endpoint(EndpointFile)+"/cgi-bin/mmwebwx-bin/webwxuploadmedia?"+params.Encode(), reqBody)
+		if e != nil {
+			err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(e, "create request error"))
+			return
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, e := api.request(req)
+		if e != nil {
+			err = newAPIError("webwxuploadmedia", nil, nil, errors.Wrap(e, "request error"))
+			return
+		}
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			err = newAPIError("webwxuploadmedia", resp, body, errors.Wrap(err, "read response body error"))
+			return
+		}
+		var umResp datastruct.UploadMediaRespond
+		err = json.Unmarshal(body, &umResp)
+		if err != nil {
+			err = newAPIError("webwxuploadmedia", resp, body, errors.Wrap(err, "UnMarshal respond json fail"))
+			return
+		}
+		if umResp.BaseResponse.Ret != 0 {
+			err = api.newRetError("webwxuploadmedia", resp, body, umResp.BaseResponse.Ret, umResp.BaseResponse.ErrMsg)
+			return
+		}
+		// 只有最后一个分片上传完成后才会返回MediaID
+		mediaID = umResp.MediaID
+	}
+	if mediaID == "" {
+		err = errors.New("upload finished but MediaId is empty")
+		return
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package wwdk
+
+import (
+	"context"
+	"github.com/getsentry/sentry-go"
+	"github.com/ikuiki/wwdk/datastruct"
+)
+
+// 此文件内的方法主要为WechatWeb管理群聊的方法
+
+// CreateChatroomContext 创建群聊
+// 创建成功后会立即获取新群的信息（包括群成员）并更新到联系人列表中
+// @param topic 群名
+// @param memberUserNames 要拉入群聊的联系人的UserName
+// @return userName 新的群的UserName(以@@开头)
+func (wxwb *WechatWeb) CreateChatroomContext(ctx context.Context, topic string, memberUserNames []string) (userName string, err error) {
+	userName, body, err := wxwb.currentAPI().CreateChatRoomContext(ctx, topic, memberUserNames)
+	if err != nil {
+		wxwb.captureException(err, "CreateChatroom fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	_, err = wxwb.refreshContact(ctx, userName)
+	return
+}
+
+// AddChatroomMemberContext 添加群成员
+// 添加成功后会将新成员更新到本地的群成员列表中
+// @param chatroomUserName 目标群的UserName
+// @param memberUserNames 要添加的联系人的UserName
+func (wxwb *WechatWeb) AddChatroomMemberContext(ctx context.Context, chatroomUserName string, memberUserNames []string) (err error) {
+	memberList, body, err := wxwb.currentAPI().AddChatRoomMemberContext(ctx, chatroomUserName, memberUserNames)
+	if err != nil {
+		wxwb.captureException(err, "AddChatroomMember fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.addLocalChatroomMember(chatroomUserName, memberList)
+	return
+}
+
+// InviteChatroomMemberContext 邀请群成员
+// 群成员较多时无法直接添加，需要以邀请的方式加入
+// 被邀请的联系人接受邀请后才会成为群成员，因此不会更新本地的群成员列表，届时由同步收到的群成员变更更新
+// @param chatroomUserName 目标群的UserName
+// @param memberUserNames 要邀请的联系人的UserName
+func (wxwb *WechatWeb) InviteChatroomMemberContext(ctx context.Context, chatroomUserName string, memberUserNames []string) (err error) {
+	_, body, err := wxwb.currentAPI().InviteChatRoomMemberContext(ctx, chatroomUserName, memberUserNames)
+	if err != nil {
+		wxwb.captureException(err, "InviteChatroomMember fatal", sentry.LevelError, extraData{"body", string(body)})
+	}
+	return
+}
+
+// DelChatroomMemberContext 移除群成员
+// 需要为群管理员，移除成功后会同时从本地的群成员列表中移除
+// @param chatroomUserName 目标群的UserName
+// @param memberUserNames 要移除的群成员的UserName
+func (wxwb *WechatWeb) DelChatroomMemberContext(ctx context.Context, chatroomUserName string, memberUserNames []string) (err error) {
+	body, err := wxwb.currentAPI().DelChatRoomMemberContext(ctx, chatroomUserName, memberUserNames)
+	if err != nil {
+		wxwb.captureException(err, "DelChatroomMember fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	delMap := make(map[string]bool)
+	for _, userName := range memberUserNames {
+		delMap[userName] = true
+	}
+	// 读取与写回之间持有锁，避免覆盖同步协程同时对该群成员的修改
+	wxwb.updateContact(chatroomUserName, func(chatroom datastruct.Contact) datastruct.Contact {
+		var newMemberList []datastruct.Member
+		for _, member := range chatroom.MemberList {
+			if !delMap[member.UserName] {
+				newMemberList = append(newMemberList, member)
+			}
+		}
+		chatroom.MemberList = newMemberList
+		chatroom.MemberCount = int64(len(newMemberList))
+		return chatroom
+	})
+	return
+}
+
+// addLocalChatroomMember 将新成员更新到本地的群成员列表中
+// 服务器推送的联系人变更延迟较大，所以在操作成功后直接更新本地列表
+func (wxwb *WechatWeb) addLocalChatroomMember(chatroomUserName string, memberList []datastruct.Member) {
+	wxwb.updateContact(chatroomUserName, func(chatroom datastruct.Contact) datastruct.Contact {
+		// 复制一份成员列表再追加，已经返回给调用方的联系人可能与其共用底层数组
+		newMemberList := append([]datastruct.Member(nil), chatroom.MemberList...)
+		for _, member := range memberList {
+			if _, err := chatroom.GetMember(member.UserName); err == nil {
+				// 已经是群成员了
+				continue
+			}
+			newMemberList = append(newMemberList, member)
+		}
+		chatroom.MemberList = newMemberList
+		chatroom.MemberCount = int64(len(newMemberList))
+		return chatroom
+	})
+}
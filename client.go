@@ -0,0 +1,170 @@
+package wwdk
+
+import (
+	"context"
+	"io"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/datastruct"
+)
+
+// Client 微信网页版客户端对外提供的方法
+// WechatWeb实现了此接口，业务代码依赖此接口而非*WechatWeb时可以方便地mock
+type Client interface {
+	// 登陆与同步
+
+	// Login 登陆方法总成
+	Login(loginChannel chan<- LoginChannelItem)
+	// LoginContext 同Login，可通过ctx取消请求
+	LoginContext(ctx context.Context, loginChannel chan<- LoginChannelItem)
+	// Logout 退出登录
+	Logout() (err error)
+	// LogoutContext 同Logout，可通过ctx取消请求
+	LogoutContext(ctx context.Context) (err error)
+	// StartServe 启动消息同步服务
+	StartServe(syncChannel chan<- SyncChannelItem)
+	// StartServeContext 同StartServe，可通过ctx取消请求
+	StartServeContext(ctx context.Context, syncChannel chan<- SyncChannelItem)
+
+	// 获取信息
+
+	// GetUser 获取当前登陆的用户
+	GetUser() (user datastruct.User, err error)
+	// GetContact 根据UserName获取联系人
+	GetContact(username string) (contact datastruct.Contact, err error)
+	// GetContactByAlias 根据Alias获取联系人
+	GetContactByAlias(alias string) (contact datastruct.Contact, err error)
+	// GetContactByNickname 根据昵称获取联系人
+	GetContactByNickname(nickname string) (contact datastruct.Contact, err error)
+	// GetContactByRemarkName 根据备注获取联系人
+	GetContactByRemarkName(remarkName string) (contact datastruct.Contact, err error)
+	// GetContactList 获取联系人列表
+	GetContactList() (contacts []datastruct.Contact)
+	// GetRunInfo 获取运行统计信息
+	GetRunInfo() (runinfo WechatRunInfo)
+	// State 获取当前的会话状态
+	State() SessionState
+	// SubscribeState 订阅会话状态变更
+	SubscribeState(ch chan<- StateTransition) (unsubscribe func())
+
+	// 发送与操作
+
+	// StatusNotify 消息已读通知
+	StatusNotify(toUserName string, code int64) (err error)
+	// StatusNotifyContext 同StatusNotify，可通过ctx取消请求
+	StatusNotifyContext(ctx context.Context, toUserName string, code int64) (err error)
+	// SendTextMessage 发送文字消息
+	SendTextMessage(toUserName, content string) (msgID, localID string, err error)
+	// SendTextMessageContext 同SendTextMessage，可通过ctx取消请求
+	SendTextMessageContext(ctx context.Context, toUserName, content string) (msgID, localID string, err error)
+	// SendImageMessage 发送图片消息
+	SendImageMessage(toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendImageMessageContext 同SendImageMessage，可通过ctx取消请求
+	SendImageMessageContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendVideoMessage 发送视频消息
+	SendVideoMessage(toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendVideoMessageContext 同SendVideoMessage，可通过ctx取消请求
+	SendVideoMessageContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendFileMessage 发送文件消息
+	SendFileMessage(toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendFileMessageContext 同SendFileMessage，可通过ctx取消请求
+	SendFileMessageContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendEmoticon 发送动图
+	SendEmoticon(toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendEmoticonContext 同SendEmoticon，可通过ctx取消请求
+	SendEmoticonContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error)
+	// SendEmoticonByMd5 通过md5发送已有的动图
+	SendEmoticonByMd5(toUserName, emoticonMd5 string) (msgID, localID string, err error)
+	// SendEmoticonByMd5Context 同SendEmoticonByMd5，可通过ctx取消请求
+	SendEmoticonByMd5Context(ctx context.Context, toUserName, emoticonMd5 string) (msgID, localID string, err error)
+	// SendRevokeMessage 撤回消息
+	SendRevokeMessage(svrMsgID, clientMsgID, toUserName string) (err error)
+	// SendRevokeMessageContext 同SendRevokeMessage，可通过ctx取消请求
+	SendRevokeMessageContext(ctx context.Context, svrMsgID, clientMsgID, toUserName string) (err error)
+	// ModifyUserRemakName 修改联系人备注
+	ModifyUserRemakName(userName, remarkName string) (err error)
+	// ModifyUserRemakNameContext 同ModifyUserRemakName，可通过ctx取消请求
+	ModifyUserRemakNameContext(ctx context.Context, userName, remarkName string) (err error)
+	// AcceptFriendRequest 接受好友请求
+	AcceptFriendRequest(msg datastruct.Message) (err error)
+	// AcceptFriendRequestContext 同AcceptFriendRequest，可通过ctx取消请求
+	AcceptFriendRequestContext(ctx context.Context, msg datastruct.Message) (err error)
+	// ModifyChatRoomTopic 修改群名
+	ModifyChatRoomTopic(userName, newTopic string) (err error)
+	// ModifyChatRoomTopicContext 同ModifyChatRoomTopic，可通过ctx取消请求
+	ModifyChatRoomTopicContext(ctx context.Context, userName, newTopic string) (err error)
+	// CreateChatroom 创建群聊
+	CreateChatroom(topic string, memberUserNames []string) (userName string, err error)
+	// CreateChatroomContext 同CreateChatroom，可通过ctx取消请求
+	CreateChatroomContext(ctx context.Context, topic string, memberUserNames []string) (userName string, err error)
+	// AddChatroomMember 添加群成员
+	AddChatroomMember(chatroomUserName string, memberUserNames []string) (err error)
+	// AddChatroomMemberContext 同AddChatroomMember，可通过ctx取消请求
+	AddChatroomMemberContext(ctx context.Context, chatroomUserName string, memberUserNames []string) (err error)
+	// InviteChatroomMember 邀请群成员
+	InviteChatroomMember(chatroomUserName string, memberUserNames []string) (err error)
+	// InviteChatroomMemberContext 同InviteChatroomMember，可通过ctx取消请求
+	InviteChatroomMemberContext(ctx context.Context, chatroomUserName string, memberUserNames []string) (err error)
+	// DelChatroomMember 移除群成员
+	DelChatroomMember(chatroomUserName string, memberUserNames []string) (err error)
+	// DelChatroomMemberContext 同DelChatroomMember，可通过ctx取消请求
+	DelChatroomMemberContext(ctx context.Context, chatroomUserName string, memberUserNames []string) (err error)
+
+	// 媒体文件
+
+	// StreamMessageImage 以流的方式获取消息图片
+	StreamMessageImage(msg datastruct.Message) (stream *api.MediaStream, err error)
+	// StreamMessageImageContext 同StreamMessageImage，可通过ctx取消请求
+	StreamMessageImageContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error)
+	// SaveMessageImage 保存消息图片
+	SaveMessageImage(msg datastruct.Message) (filename string, err error)
+	// SaveMessageImageContext 同SaveMessageImage，可通过ctx取消请求
+	SaveMessageImageContext(ctx context.Context, msg datastruct.Message) (filename string, err error)
+	// StreamMessageVoice 以流的方式获取消息声音
+	StreamMessageVoice(msg datastruct.Message) (stream *api.MediaStream, err error)
+	// StreamMessageVoiceContext 同StreamMessageVoice，可通过ctx取消请求
+	StreamMessageVoiceContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error)
+	// SaveMessageVoice 保存消息声音
+	SaveMessageVoice(msg datastruct.Message) (filename string, err error)
+	// SaveMessageVoiceContext 同SaveMessageVoice，可通过ctx取消请求
+	SaveMessageVoiceContext(ctx context.Context, msg datastruct.Message) (filename string, err error)
+	// StreamMessageVideo 以流的方式获取消息视频
+	StreamMessageVideo(msg datastruct.Message) (stream *api.MediaStream, err error)
+	// StreamMessageVideoContext 同StreamMessageVideo，可通过ctx取消请求
+	StreamMessageVideoContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error)
+	// SaveMessageVideo 保存消息视频
+	SaveMessageVideo(msg datastruct.Message) (filename string, err error)
+	// SaveMessageVideoContext 同SaveMessageVideo，可通过ctx取消请求
+	SaveMessageVideoContext(ctx context.Context, msg datastruct.Message) (filename string, err error)
+	// StreamMessageFile 以流的方式获取消息附件
+	StreamMessageFile(msg datastruct.Message) (stream *api.MediaStream, err error)
+	// StreamMessageFileContext 同StreamMessageFile，可通过ctx取消请求
+	StreamMessageFileContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error)
+	// SaveMessageFile 保存消息附件
+	SaveMessageFile(msg datastruct.Message) (filename string, err error)
+	// SaveMessageFileContext 同SaveMessageFile，可通过ctx取消请求
+	SaveMessageFileContext(ctx context.Context, msg datastruct.Message) (filename string, err error)
+	// StreamContactImg 以流的方式获取联系人头像
+	StreamContactImg(contact datastruct.Contact) (stream *api.MediaStream, err error)
+	// StreamContactImgContext 同StreamContactImg，可通过ctx取消请求
+	StreamContactImgContext(ctx context.Context, contact datastruct.Contact) (stream *api.MediaStream, err error)
+	// SaveContactImg 保存联系人头像
+	SaveContactImg(contact datastruct.Contact) (filename string, err error)
+	// SaveContactImgContext 同SaveContactImg，可通过ctx取消请求
+	SaveContactImgContext(ctx context.Context, contact datastruct.Contact) (filename string, err error)
+	// SaveUserImg 保存登陆用户的头像
+	SaveUserImg(user datastruct.User) (filename string, err error)
+	// SaveUserImgContext 同SaveUserImg，可通过ctx取消请求
+	SaveUserImgContext(ctx context.Context, user datastruct.User) (filename string, err error)
+	// StreamMemberImg 以流的方式获取群成员的头像
+	StreamMemberImg(member datastruct.Member, chatroomID string) (stream *api.MediaStream, err error)
+	// StreamMemberImgContext 同StreamMemberImg，可通过ctx取消请求
+	StreamMemberImgContext(ctx context.Context, member datastruct.Member, chatroomID string) (stream *api.MediaStream, err error)
+	// SaveMemberImg 保存群成员的头像
+	SaveMemberImg(member datastruct.Member, chatroomID string) (filename string, err error)
+	// SaveMemberImgContext 同SaveMemberImg，可通过ctx取消请求
+	SaveMemberImgContext(ctx context.Context, member datastruct.Member, chatroomID string) (filename string, err error)
+}
+
+// 确保WechatWeb实现了Client
+var _ Client = (*WechatWeb)(nil)
@@ -0,0 +1,151 @@
+package wwdk
+
+import (
+	"context"
+	"io"
+
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/datastruct"
+)
+
+// 此文件中为不带context的方法，均以context.Background()调用对应的Context方法
+
+// StatusNotify 同StatusNotifyContext
+func (wxwb *WechatWeb) StatusNotify(toUserName string, code int64) (err error) {
+	return wxwb.StatusNotifyContext(context.Background(), toUserName, code)
+}
+
+// SendTextMessage 同SendTextMessageContext
+func (wxwb *WechatWeb) SendTextMessage(toUserName, content string) (msgID, localID string, err error) {
+	return wxwb.SendTextMessageContext(context.Background(), toUserName, content)
+}
+
+// SendImageMessage 同SendImageMessageContext
+func (wxwb *WechatWeb) SendImageMessage(toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	return wxwb.SendImageMessageContext(context.Background(), toUserName, fileName, file)
+}
+
+// SendVideoMessage 同SendVideoMessageContext
+func (wxwb *WechatWeb) SendVideoMessage(toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	return wxwb.SendVideoMessageContext(context.Background(), toUserName, fileName, file)
+}
+
+// SendFileMessage 同SendFileMessageContext
+func (wxwb *WechatWeb) SendFileMessage(toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	return wxwb.SendFileMessageContext(context.Background(), toUserName, fileName, file)
+}
+
+// SendEmoticon 同SendEmoticonContext
+func (wxwb *WechatWeb) SendEmoticon(toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	return wxwb.SendEmoticonContext(context.Background(), toUserName, fileName, file)
+}
+
+// SendEmoticonByMd5 同SendEmoticonByMd5Context
+func (wxwb *WechatWeb) SendEmoticonByMd5(toUserName, emoticonMd5 string) (msgID, localID string, err error) {
+	return wxwb.SendEmoticonByMd5Context(context.Background(), toUserName, emoticonMd5)
+}
+
+// SendRevokeMessage 同SendRevokeMessageContext
+func (wxwb *WechatWeb) SendRevokeMessage(svrMsgID, clientMsgID, toUserName string) (err error) {
+	return wxwb.SendRevokeMessageContext(context.Background(), svrMsgID, clientMsgID, toUserName)
+}
+
+// ModifyUserRemakName 同ModifyUserRemakNameContext
+func (wxwb *WechatWeb) ModifyUserRemakName(userName, remarkName string) (err error) {
+	return wxwb.ModifyUserRemakNameContext(context.Background(), userName, remarkName)
+}
+
+// AcceptFriendRequest 同AcceptFriendRequestContext
+func (wxwb *WechatWeb) AcceptFriendRequest(msg datastruct.Message) (err error) {
+	return wxwb.AcceptFriendRequestContext(context.Background(), msg)
+}
+
+// ModifyChatRoomTopic 同ModifyChatRoomTopicContext
+func (wxwb *WechatWeb) ModifyChatRoomTopic(userName, newTopic string) (err error) {
+	return wxwb.ModifyChatRoomTopicContext(context.Background(), userName, newTopic)
+}
+
+// CreateChatroom 同CreateChatroomContext
+func (wxwb *WechatWeb) CreateChatroom(topic string, memberUserNames []string) (userName string, err error) {
+	return wxwb.CreateChatroomContext(context.Background(), topic, memberUserNames)
+}
+
+// AddChatroomMember 同AddChatroomMemberContext
+func (wxwb *WechatWeb) AddChatroomMember(chatroomUserName string, memberUserNames []string) (err error) {
+	return wxwb.AddChatroomMemberContext(context.Background(), chatroomUserName, memberUserNames)
+}
+
+// InviteChatroomMember 同InviteChatroomMemberContext
+func (wxwb *WechatWeb) InviteChatroomMember(chatroomUserName string, memberUserNames []string) (err error) {
+	return wxwb.InviteChatroomMemberContext(context.Background(), chatroomUserName, memberUserNames)
+}
+
+// DelChatroomMember 同DelChatroomMemberContext
+func (wxwb *WechatWeb) DelChatroomMember(chatroomUserName string, memberUserNames []string) (err error) {
+	return wxwb.DelChatroomMemberContext(context.Background(), chatroomUserName, memberUserNames)
+}
+
+// StreamMessageImage 同StreamMessageImageContext
+func (wxwb *WechatWeb) StreamMessageImage(msg datastruct.Message) (stream *api.MediaStream, err error) {
+	return wxwb.StreamMessageImageContext(context.Background(), msg)
+}
+
+// SaveMessageImage 同SaveMessageImageContext
+func (wxwb *WechatWeb) SaveMessageImage(msg datastruct.Message) (filename string, err error) {
+	return wxwb.SaveMessageImageContext(context.Background(), msg)
+}
+
+// StreamMessageVoice 同StreamMessageVoiceContext
+func (wxwb *WechatWeb) StreamMessageVoice(msg datastruct.Message) (stream *api.MediaStream, err error) {
+	return wxwb.StreamMessageVoiceContext(context.Background(), msg)
+}
+
+// SaveMessageVoice 同SaveMessageVoiceContext
+func (wxwb *WechatWeb) SaveMessageVoice(msg datastruct.Message) (filename string, err error) {
+	return wxwb.SaveMessageVoiceContext(context.Background(), msg)
+}
+
+// StreamMessageVideo 同StreamMessageVideoContext
+func (wxwb *WechatWeb) StreamMessageVideo(msg datastruct.Message) (stream *api.MediaStream, err error) {
+	return wxwb.StreamMessageVideoContext(context.Background(), msg)
+}
+
+// SaveMessageVideo 同SaveMessageVideoContext
+func (wxwb *WechatWeb) SaveMessageVideo(msg datastruct.Message) (filename string, err error) {
+	return wxwb.SaveMessageVideoContext(context.Background(), msg)
+}
+
+// StreamMessageFile 同StreamMessageFileContext
+func (wxwb *WechatWeb) StreamMessageFile(msg datastruct.Message) (stream *api.MediaStream, err error) {
+	return wxwb.StreamMessageFileContext(context.Background(), msg)
+}
+
+// SaveMessageFile 同SaveMessageFileContext
+func (wxwb *WechatWeb) SaveMessageFile(msg datastruct.Message) (filename string, err error) {
+	return wxwb.SaveMessageFileContext(context.Background(), msg)
+}
+
+// StreamContactImg 同StreamContactImgContext
+func (wxwb *WechatWeb) StreamContactImg(contact datastruct.Contact) (stream *api.MediaStream, err error) {
+	return wxwb.StreamContactImgContext(context.Background(), contact)
+}
+
+// SaveContactImg 同SaveContactImgContext
+func (wxwb *WechatWeb) SaveContactImg(contact datastruct.Contact) (filename string, err error) {
+	return wxwb.SaveContactImgContext(context.Background(), contact)
+}
+
+// SaveUserImg 同SaveUserImgContext
+func (wxwb *WechatWeb) SaveUserImg(user datastruct.User) (filename string, err error) {
+	return wxwb.SaveUserImgContext(context.Background(), user)
+}
+
+// StreamMemberImg 同StreamMemberImgContext
+func (wxwb *WechatWeb) StreamMemberImg(member datastruct.Member, chatroomID string) (stream *api.MediaStream, err error) {
+	return wxwb.StreamMemberImgContext(context.Background(), member, chatroomID)
+}
+
+// SaveMemberImg 同SaveMemberImgContext
+func (wxwb *WechatWeb) SaveMemberImg(member datastruct.Member, chatroomID string) (filename string, err error) {
+	return wxwb.SaveMemberImgContext(context.Background(), member, chatroomID)
+}
@@ -115,3 +115,27 @@ func (contact Contact) GetMember(userName string) (member Member, err error) {
 	}
 	return
 }
+
+// UpdateMembers 更新群成员，返回更新后的联系人
+// 按UserName替换已存在的成员，不存在的成员添加到列表末尾
+func (contact Contact) UpdateMembers(members []Member) Contact {
+	memberList := make([]Member, len(contact.MemberList), len(contact.MemberList)+len(members))
+	copy(memberList, contact.MemberList)
+	index := make(map[string]int, len(memberList))
+	for i, m := range memberList {
+		index[m.UserName] = i
+	}
+	for _, m := range members {
+		if i, ok := index[m.UserName]; ok {
+			memberList[i] = m
+		} else {
+			index[m.UserName] = len(memberList)
+			memberList = append(memberList, m)
+		}
+	}
+	contact.MemberList = memberList
+	if int64(len(memberList)) > contact.MemberCount {
+		contact.MemberCount = int64(len(memberList))
+	}
+	return contact
+}
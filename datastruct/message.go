@@ -16,6 +16,8 @@ const (
 	ImageMsg MessageType = 3
 	// VoiceMsg 音频消息
 	VoiceMsg MessageType = 34
+	// VerifyMsg 好友请求消息
+	VerifyMsg MessageType = 37
 	// ContactCardMsg 名片
 	ContactCardMsg MessageType = 42
 	// LittleVideoMsg 小视频消息
@@ -61,6 +61,8 @@ type SendMessage struct {
 	MediaID      string      `json:"MediaId"`
 	ToUserName   string      `json:"ToUserName"`
 	Type         MessageType `json:"Type"`
+	EmojiFlag    int64       `json:"EmojiFlag,omitempty"`   // 发送动图时固定填2
+	EMoticonMd5  string      `json:"EMoticonMd5,omitempty"` // 通过md5发送已有的动图时填写
 }
 
 // SendMessageRequest 发送消息请求
@@ -92,3 +94,77 @@ type ModifyChatRoomTopicRequest struct {
 	ChatRoomName string       `json:"ChatRoomName"`
 	NewTopic     string       `json:"NewTopic"`
 }
+
+// UploadMediaRequest 上传文件的请求，以json字符串的形式放在multipart表单的uploadmediarequest字段中
+type UploadMediaRequest struct {
+	UploadType    int64        `json:"UploadType"`
+	BaseRequest   *BaseRequest `json:"BaseRequest"`
+	ClientMediaID int64        `json:"ClientMediaId"`
+	TotalLen      int64        `json:"TotalLen"`
+	StartPos      int64        `json:"StartPos"`
+	DataLen       int64        `json:"DataLen"`
+	MediaType     int64        `json:"MediaType"`
+	FromUserName  string       `json:"FromUserName"`
+	ToUserName    string       `json:"ToUserName"`
+	FileMd5       string       `json:"FileMd5"`
+}
+
+// VerifyUserOpcode 好友验证操作码
+type VerifyUserOpcode int64
+
+const (
+	// VerifyUserOpcodeAccept 接受好友请求
+	VerifyUserOpcodeAccept VerifyUserOpcode = 3
+)
+
+// VerifyUserListItem 好友验证请求的列表元素
+type VerifyUserListItem struct {
+	Value            string `json:"Value"`
+	VerifyUserTicket string `json:"VerifyUserTicket"`
+}
+
+// VerifyUserRequest 好友验证请求
+type VerifyUserRequest struct {
+	BaseRequest        *BaseRequest         `json:"BaseRequest"`
+	Opcode             VerifyUserOpcode     `json:"Opcode"`
+	VerifyUserListSize int64                `json:"VerifyUserListSize"`
+	VerifyUserList     []VerifyUserListItem `json:"VerifyUserList"`
+	VerifyContent      string               `json:"VerifyContent"`
+	SceneListCount     int64                `json:"SceneListCount"`
+	SceneList          []int64              `json:"SceneList"`
+	Skey               string               `json:"skey"`
+}
+
+// AddChatRoomMemberRequest 添加群成员的请求
+type AddChatRoomMemberRequest struct {
+	BaseRequest   *BaseRequest `json:"BaseRequest"`
+	AddMemberList string       `json:"AddMemberList"` // 要添加的成员的UserName，多个用英文逗号分隔
+	ChatRoomName  string       `json:"ChatRoomName"`
+}
+
+// InviteChatRoomMemberRequest 邀请群成员的请求（群成员较多时需要以邀请的方式加入）
+type InviteChatRoomMemberRequest struct {
+	BaseRequest      *BaseRequest `json:"BaseRequest"`
+	InviteMemberList string       `json:"InviteMemberList"` // 要邀请的成员的UserName，多个用英文逗号分隔
+	ChatRoomName     string       `json:"ChatRoomName"`
+}
+
+// DelChatRoomMemberRequest 移除群成员的请求
+type DelChatRoomMemberRequest struct {
+	BaseRequest   *BaseRequest `json:"BaseRequest"`
+	DelMemberList string       `json:"DelMemberList"` // 要移除的成员的UserName，多个用英文逗号分隔
+	ChatRoomName  string       `json:"ChatRoomName"`
+}
+
+// CreateChatRoomRequestMember 创建群聊请求中的成员
+type CreateChatRoomRequestMember struct {
+	UserName string `json:"UserName"`
+}
+
+// CreateChatRoomRequest 创建群聊的请求
+type CreateChatRoomRequest struct {
+	BaseRequest *BaseRequest                  `json:"BaseRequest"`
+	MemberCount int64                         `json:"MemberCount"`
+	MemberList  []CreateChatRoomRequestMember `json:"MemberList"`
+	Topic       string                        `json:"Topic"`
+}
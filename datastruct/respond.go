@@ -62,6 +62,36 @@ type WebwxSyncRespondDelContactListItem struct {
 	UserName    string `json:"UserName"`
 }
 
+// BuffString 微信以{"Buff":"..."}形式返回的字符串
+type BuffString struct {
+	Buff string `json:"Buff"`
+}
+
+// ModChatRoomMember 同步时发现的群成员变更
+type ModChatRoomMember struct {
+	UserName    string   `json:"UserName"` // 群聊的UserName
+	MemberCount int64    `json:"MemberCount"`
+	MemberList  []Member `json:"MemberList"` // 有变更的群成员
+}
+
+// Profile 同步时返回的当前登陆用户的资料
+// BitFlag为0时资料没有变更，变更时未变更的字段为空
+type Profile struct {
+	Alias             string      `json:"Alias"`
+	BindEmail         BuffString  `json:"BindEmail"`
+	BindMobile        BuffString  `json:"BindMobile"`
+	BindUin           int64       `json:"BindUin"`
+	BitFlag           int64       `json:"BitFlag"`
+	HeadImgUpdateFlag int64       `json:"HeadImgUpdateFlag"` // 头像是否更新，不为0时HeadImgURL为新的头像
+	HeadImgURL        string      `json:"HeadImgUrl"`
+	NickName          *BuffString `json:"NickName"`
+	PersonalCard      int64       `json:"PersonalCard"`
+	Sex               int64       `json:"Sex"`
+	Signature         string      `json:"Signature"`
+	Status            int64       `json:"Status"`
+	UserName          *BuffString `json:"UserName"`
+}
+
 // WebwxSyncRespond 取回消息的返回
 type WebwxSyncRespond struct {
 	BaseResponse           *BaseResponse                        `json:"BaseResponse"`
@@ -71,35 +101,20 @@ type WebwxSyncRespond struct {
 	DelContactCount        int64                                `json:"DelContactCount"`
 	DelContactList         []WebwxSyncRespondDelContactListItem `json:"DelContactList"`
 	ModChatRoomMemberCount int64                                `json:"ModChatRoomMemberCount"`
-	ModChatRoomMemberList  []interface{}                        `json:"ModChatRoomMemberList"`
+	ModChatRoomMemberList  []ModChatRoomMember                  `json:"ModChatRoomMemberList"`
 	ModContactCount        int64                                `json:"ModContactCount"`
 	ModContactList         []Contact                            `json:"ModContactList"`
-	Profile                *struct {
-		Alias     string `json:"Alias"`
-		BindEmail struct {
-			Buff string `json:"Buff"`
-		} `json:"BindEmail"`
-		BindMobile struct {
-			Buff string `json:"Buff"`
-		} `json:"BindMobile"`
-		BindUin           int64  `json:"BindUin"`
-		BitFlag           int64  `json:"BitFlag"`
-		HeadImgUpdateFlag int64  `json:"HeadImgUpdateFlag"`
-		HeadImgURL        string `json:"HeadImgUrl"`
-		NickName          *struct {
-			Buff string `json:"Buff"`
-		} `json:"NickName"`
-		PersonalCard int64  `json:"PersonalCard"`
-		Sex          int64  `json:"Sex"`
-		Signature    string `json:"Signature"`
-		Status       int64  `json:"Status"`
-		UserName     *struct {
-			Buff string `json:"Buff"`
-		} `json:"UserName"`
-	} `json:"Profile"`
-	SKey         string   `json:"SKey"`
-	SyncCheckKey *SyncKey `json:"SyncCheckKey"`
-	SyncKey      *SyncKey `json:"SyncKey"`
+	Profile                *Profile                             `json:"Profile"`
+	SKey                   string                               `json:"SKey"`
+	SyncCheckKey           *SyncKey                             `json:"SyncCheckKey"`
+	SyncKey                *SyncKey                             `json:"SyncKey"`
+}
+
+// PushLoginRespond 推送登陆的返回
+type PushLoginRespond struct {
+	Ret  string `json:"ret"`
+	Msg  string `json:"msg"`
+	UUID string `json:"uuid"`
 }
 
 // StatusNotifyRespond 状态通知请求的返回
@@ -131,5 +146,39 @@ type ModifyRemarkRespond struct {
 type ModifyChatRoomTopicRespond struct {
 	BaseResponse *BaseResponse `json:"BaseResponse"`
 	MemberCount  int64         `json:"MemberCount"`
-	MemberList   []interface{} `json:"MemberList"`
+	MemberList   []Member      `json:"MemberList"`
+}
+
+// UpdateChatRoomMemberRespond 添加、邀请、移除群成员的返回
+type UpdateChatRoomMemberRespond struct {
+	BaseResponse *BaseResponse `json:"BaseResponse"`
+	MemberCount  int64         `json:"MemberCount"`
+	MemberList   []Member      `json:"MemberList"`
+}
+
+// UploadMediaRespond 上传文件的返回
+type UploadMediaRespond struct {
+	BaseResponse      *BaseResponse `json:"BaseResponse"`
+	MediaID           string        `json:"MediaId"`
+	StartPos          int64         `json:"StartPos"`
+	CDNThumbImgHeight int64         `json:"CDNThumbImgHeight"`
+	CDNThumbImgWidth  int64         `json:"CDNThumbImgWidth"`
+	EncryFileName     string        `json:"EncryFileName"`
+}
+
+// VerifyUserRespond 好友验证请求的返回
+type VerifyUserRespond struct {
+	BaseResponse *BaseResponse `json:"BaseResponse"`
+}
+
+// CreateChatRoomRespond 创建群聊的返回
+type CreateChatRoomRespond struct {
+	BaseResponse *BaseResponse `json:"BaseResponse"`
+	Topic        string        `json:"Topic"`
+	PYInitial    string        `json:"PYInitial"`
+	QuanPin      string        `json:"QuanPin"`
+	MemberCount  int64         `json:"MemberCount"`
+	MemberList   []Member      `json:"MemberList"`
+	ChatRoomName string        `json:"ChatRoomName"` // 新的群的UserName
+	BlackList    string        `json:"BlackList"`
 }
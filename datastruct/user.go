@@ -22,3 +22,21 @@ type User struct {
 	VerifyFlag        int64  `json:"VerifyFlag"`
 	WebWxPluginSwitch int64  `json:"WebWxPluginSwitch"`
 }
+
+// ApplyProfile 将同步时返回的资料变更应用到用户上，返回更新后的用户
+// 只更新profile中有值的字段
+func (user User) ApplyProfile(profile Profile) User {
+	if profile.NickName != nil && profile.NickName.Buff != "" {
+		user.NickName = profile.NickName.Buff
+	}
+	if profile.HeadImgUpdateFlag != 0 && profile.HeadImgURL != "" {
+		user.HeadImgURL = profile.HeadImgURL
+	}
+	if profile.Signature != "" {
+		user.Signature = profile.Signature
+	}
+	if profile.Sex != 0 {
+		user.Sex = profile.Sex
+	}
+	return user
+}
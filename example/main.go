@@ -18,7 +18,8 @@ func main() {
 	// 初始化一个文件loginStorer
 	storer := storer.MustNewFileStorer("loginInfo.txt")
 	// 将loginStorer作为配置传入构造函数，可以用来记录登陆状态
-	wx, err := wwdk.NewWechatWeb(storer)
+	// 传入AutoRelogin后，会话失效时会自动重新登陆
+	wx, err := wwdk.NewWechatWeb(storer, &wwdk.AutoRelogin{})
 	if err != nil {
 		panic("Get new wechatweb client error: " + err.Error())
 	}
@@ -31,6 +32,9 @@ func main() {
 		case wwdk.LoginStatusWaitForScan:
 			// 返回了登陆二维码链接，输出到屏幕
 			qrterminal.Generate(item.Msg, qrterminal.L, os.Stdout)
+		case wwdk.LoginStatusWaitForPushConfirm:
+			// 已推送登陆确认到手机
+			fmt.Println("please confirm login on your phone")
 		case wwdk.LoginStatusScanedWaitForLogin:
 			// 用户已扫码
 			fmt.Println("scaned")
@@ -43,6 +47,9 @@ func main() {
 		case wwdk.LoginStatusInitFinish:
 			// 初始化完成
 			fmt.Println("init finish")
+		case wwdk.LoginStatusGettingContact:
+			// 分页获取联系人中
+			fmt.Printf("getting contact: %s\n", item.Msg)
 		case wwdk.LoginStatusGotContact:
 			// 获取联系人完成
 			fmt.Println("got contact")
@@ -95,6 +102,17 @@ func main() {
 					fmt.Println("New contact: ", item.Contact.NickName)
 				}
 				contactMap[item.Contact.UserName] = *item.Contact
+			case wwdk.SyncStatusRelogin:
+				// 开启了自动重新登陆时，会话失效后重新登陆
+				switch item.Login.Code {
+				case wwdk.LoginStatusWaitForPushConfirm:
+					fmt.Println("session expired, please confirm relogin on your phone")
+				case wwdk.LoginStatusWaitForScan:
+					fmt.Println("session expired, please scan qrcode to relogin: ", item.Login.Msg)
+				}
+			case wwdk.SyncStatusModifySelf:
+				// 当前登陆用户的资料发生变更
+				fmt.Println("Modify self profile: ", item.User.NickName)
 			// 收到新信息
 			case wwdk.SyncStatusNewMessage:
 				// 根据收到的信息类型分别处理
@@ -124,6 +142,11 @@ func main() {
 				case datastruct.VoiceMsg:
 					// 处理声音消息
 					processVoiceMessage(wx, msg)
+				case datastruct.LinkMsg:
+					if msg.AppMsgType == datastruct.ReciveFileAppmsg {
+						// 处理文件消息
+						processFileMessage(wx, msg)
+					}
 				}
 			case wwdk.SyncStatusErrorOccurred:
 				// 发生非致命性错误
@@ -227,3 +250,18 @@ func processVoiceMessage(app *wwdk.WechatWeb, msg *datastruct.Message) {
 	}
 	log.Printf("Recived a voice %s msg from %s\n", filename, from.NickName)
 }
+
+// ProcessFileMessage set file message handle
+func processFileMessage(app *wwdk.WechatWeb, msg *datastruct.Message) {
+	from, err := app.GetContact(msg.FromUserName)
+	if err != nil {
+		log.Printf("msg[%d] getContact[%s] error: %v\n", msg.MsgType, msg.FromUserName, err)
+		return
+	}
+	filename, err := app.SaveMessageFile(*msg)
+	if err != nil {
+		log.Printf("Recived a file msg from %s but save fail: %v\n", from.NickName, err)
+		return
+	}
+	log.Printf("Recived a file %s msg from %s\n", filename, from.NickName)
+}
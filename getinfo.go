@@ -1,34 +1,38 @@
 package wwdk
 
 import (
+	"context"
+	"github.com/getsentry/sentry-go"
 	"github.com/ikuiki/wwdk/datastruct"
 	"github.com/pkg/errors"
+	"sync/atomic"
 )
 
 // 此文件内的方法主要为WechatWeb暴露给外部调用获取信息的方法
 
 // GetUser 获取当前登陆用户
 func (wxwb *WechatWeb) GetUser() (user datastruct.User, err error) {
-	if wxwb.userInfo.user == nil {
+	u := wxwb.currentUser()
+	if u == nil {
 		err = errors.New("User not found")
 	} else {
-		user = *wxwb.userInfo.user
+		user = *u
 	}
 	return
 }
 
 // GetContact 根据username获取联系人
 func (wxwb *WechatWeb) GetContact(username string) (contact datastruct.Contact, err error) {
-	contact, ok := wxwb.userInfo.contactList[username]
+	contact, ok := wxwb.contact(username)
 	if !ok {
 		// 尝试获取一次
-		contactList, _, _ := wxwb.api.BatchGetContact([]datastruct.BatchGetContactRequestListItem{
+		contactList, _, _ := wxwb.currentAPI().BatchGetContact([]datastruct.BatchGetContactRequestListItem{
 			datastruct.BatchGetContactRequestListItem{
 				UserName: username,
 			},
 		})
+		wxwb.putContacts(contactList...)
 		for _, c := range contactList {
-			wxwb.userInfo.contactList[c.UserName] = c
 			if c.UserName == username {
 				wxwb.logger.Infof("User %s not found, but BatchGetContact got that", c.NickName)
 				wxwb.syncChannel <- SyncChannelItem{
@@ -46,13 +50,38 @@ func (wxwb *WechatWeb) GetContact(username string) (contact datastruct.Contact,
 	return
 }
 
-// GetContactByAlias 根据Alias获取联系人
-func (wxwb *WechatWeb) GetContactByAlias(alias string) (contact datastruct.Contact, err error) {
+// refreshContact 从服务器重新获取联系人信息
+// 获取到后更新到联系人列表中，并尝试通过同步通道发出联系人变更事件（不阻塞）
+func (wxwb *WechatWeb) refreshContact(ctx context.Context, userName string) (contact datastruct.Contact, err error) {
+	contactList, body, err := wxwb.currentAPI().BatchGetContactContext(ctx, []datastruct.BatchGetContactRequestListItem{
+		datastruct.BatchGetContactRequestListItem{
+			UserName: userName,
+		},
+	})
+	if err != nil {
+		wxwb.captureException(err, "RefreshContact fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.putContacts(contactList...)
 	found := false
-	for _, v := range wxwb.userInfo.contactList {
-		if v.Alias == alias {
-			contact = v
-			found = true
+	for _, c := range contactList {
+		c := c
+		wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+			runInfo.ContactModifyCount++
+		})
+		if wxwb.syncChannel != nil {
+			// 可能在读取syncChannel的协程中调用（如处理消息时刷新未知的发送者），
+			// 此时阻塞发送会死锁，因此没有接收方时放弃本次事件，联系人列表已经更新
+			select {
+			case wxwb.syncChannel <- SyncChannelItem{
+				Code:    SyncStatusModifyContact,
+				Contact: &c,
+			}:
+			default:
+			}
+		}
+		if c.UserName == userName {
+			contact, found = c, true
 		}
 	}
 	if !found {
@@ -61,15 +90,22 @@ func (wxwb *WechatWeb) GetContactByAlias(alias string) (contact datastruct.Conta
 	return
 }
 
+// GetContactByAlias 根据Alias获取联系人
+func (wxwb *WechatWeb) GetContactByAlias(alias string) (contact datastruct.Contact, err error) {
+	contact, found := wxwb.findContact(func(v datastruct.Contact) bool {
+		return v.Alias == alias
+	})
+	if !found {
+		err = errors.New("User not found")
+	}
+	return
+}
+
 // GetContactByNickname 根据昵称获取用户名
 func (wxwb *WechatWeb) GetContactByNickname(nickname string) (contact datastruct.Contact, err error) {
-	found := false
-	for _, v := range wxwb.userInfo.contactList {
-		if v.NickName == nickname {
-			contact = v
-			found = true
-		}
-	}
+	contact, found := wxwb.findContact(func(v datastruct.Contact) bool {
+		return v.NickName == nickname
+	})
 	if !found {
 		err = errors.New("User not found")
 	}
@@ -78,13 +114,9 @@ func (wxwb *WechatWeb) GetContactByNickname(nickname string) (contact datastruct
 
 // GetContactByRemarkName 根据备注获取用户名
 func (wxwb *WechatWeb) GetContactByRemarkName(remarkName string) (contact datastruct.Contact, err error) {
-	found := false
-	for _, v := range wxwb.userInfo.contactList {
-		if v.RemarkName == remarkName {
-			contact = v
-			found = true
-		}
-	}
+	contact, found := wxwb.findContact(func(v datastruct.Contact) bool {
+		return v.RemarkName == remarkName
+	})
 	if !found {
 		err = errors.New("User not found")
 	}
@@ -93,13 +125,15 @@ func (wxwb *WechatWeb) GetContactByRemarkName(remarkName string) (contact datast
 
 // GetContactList 获取联系人列表
 func (wxwb *WechatWeb) GetContactList() (contacts []datastruct.Contact) {
-	for _, v := range wxwb.userInfo.contactList {
-		contacts = append(contacts, v)
-	}
-	return
+	return wxwb.contacts()
 }
 
 // GetRunInfo 获取运行计数器信息
 func (wxwb *WechatWeb) GetRunInfo() (runinfo WechatRunInfo) {
-	return wxwb.runInfo
+	wxwb.loginMutex.RLock()
+	runinfo = wxwb.runInfo
+	wxwb.loginMutex.RUnlock()
+	runinfo.RetryCount = atomic.LoadUint64(&wxwb.retryCount)
+	runinfo.CircuitBreakCount = atomic.LoadUint64(&wxwb.circuitBreakCount)
+	return
 }
@@ -1,11 +1,14 @@
 package wwdk
 
 import (
+	"context"
 	"github.com/getsentry/sentry-go"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/ikuiki/wwdk/api"
 	"github.com/ikuiki/wwdk/datastruct"
 )
 
@@ -38,11 +41,17 @@ const (
 	LoginStatusGotContact LoginStatus = 6
 	// LoginStatusBatchGotContact 已获取到群聊成员
 	LoginStatusBatchGotContact LoginStatus = 7
+	// LoginStatusGettingContact 正在分页获取联系人，每获取到一页返回一次
+	// 返回Msg: 目前已获取到的联系人数
+	LoginStatusGettingContact LoginStatus = 8
+	// LoginStatusWaitForPushConfirm 已向手机推送登陆确认，等待用户在手机上确认
+	// 用户拒绝或超时未确认时会改为扫码登陆，返回LoginStatusWaitForScan
+	LoginStatusWaitForPushConfirm LoginStatus = 9
 )
 
 // 获取uuid用于扫码
-func (wxwb *WechatWeb) getUUID(loginChannel chan<- LoginChannelItem) (uuid string) {
-	uuid, body, err := wxwb.api.JsLogin()
+func (wxwb *WechatWeb) getUUID(ctx context.Context, loginChannel chan<- LoginChannelItem) (uuid string) {
+	uuid, body, err := wxwb.currentAPI().JsLoginContext(ctx)
 	if err != nil {
 		panic(fatalInfo{
 			"getUUID",
@@ -50,6 +59,7 @@ func (wxwb *WechatWeb) getUUID(loginChannel chan<- LoginChannelItem) (uuid strin
 			body,
 		})
 	}
+	wxwb.setState(StateAwaitingScan, "got qrcode uuid", nil)
 	loginChannel <- LoginChannelItem{
 		Code: LoginStatusWaitForScan,
 		Msg:  "https://login.weixin.qq.com/l/" + uuid,
@@ -57,64 +67,105 @@ func (wxwb *WechatWeb) getUUID(loginChannel chan<- LoginChannelItem) (uuid strin
 	return uuid
 }
 
+// defaultPushLoginTimeout 推送登陆后等待用户在手机上确认的默认最长时间
+const defaultPushLoginTimeout = 30 * time.Second
+
+// PushLoginTimeout 推送登陆后等待用户在手机上确认的最长时间，超时后改为扫码登陆
+// 作为配置传入NewWechatWeb后生效，不传入时为30秒
+type PushLoginTimeout time.Duration
+
+// pushLogin 使用上次登陆的Wxuin向手机推送登陆确认
+// 没有上次登陆的信息或推送失败时返回空的uuid，此时应当改为扫码登陆
+func (wxwb *WechatWeb) pushLogin(ctx context.Context) (uuid string) {
+	uuid, body, err := wxwb.currentAPI().PushLoginContext(ctx)
+	if err != nil {
+		if errors.Cause(err) != api.ErrNoUin {
+			wxwb.captureException(err, "PushLogin fail", sentry.LevelWarning, extraData{"body", string(body)})
+			wxwb.logger.Infof("Push login fail: %v, fallback to qrcode\n", err)
+		}
+		return ""
+	}
+	return uuid
+}
+
+// waitForPushConfirm 等待用户在手机上确认推送的登陆
+// 用户拒绝或超时未确认时返回空的redirectURL，此时应当改为扫码登陆
+func (wxwb *WechatWeb) waitForPushConfirm(ctx context.Context, uuid string, loginChannel chan<- LoginChannelItem) (redirectURL string) {
+	wxwb.setState(StateAwaitingConfirm, "push login sent", nil)
+	loginChannel <- LoginChannelItem{
+		Code: LoginStatusWaitForPushConfirm,
+	}
+	pushCtx, cancel := context.WithTimeout(ctx, wxwb.pushLoginTimeout)
+	defer cancel()
+	redirectURL, body, err := wxwb.pollLogin(pushCtx, uuid, loginChannel)
+	if err != nil {
+		if ctx.Err() != nil {
+			// 登陆被取消，不再继续扫码登陆
+			panic(fatalInfo{
+				"waitForPushConfirm",
+				ctx.Err(),
+				body,
+			})
+		}
+		wxwb.logger.Infof("Push login not confirmed: %v, fallback to qrcode\n", err)
+		return ""
+	}
+	return redirectURL
+}
+
 // waitForScan 等待用户扫描二维码登陆
 // 当扫码超时、扫码失败时，应当从getUUID方法重新开始
-func (wxwb *WechatWeb) waitForScan(uuid string, loginChannel chan<- LoginChannelItem) (redirectURL string) {
+func (wxwb *WechatWeb) waitForScan(ctx context.Context, uuid string, loginChannel chan<- LoginChannelItem) (redirectURL string) {
+	redirectURL, body, err := wxwb.pollLogin(ctx, uuid, loginChannel)
+	if err != nil {
+		panic(fatalInfo{
+			"waitForScan",
+			err,
+			body,
+		})
+	}
+	return redirectURL
+}
+
+// pollLogin 轮询等待用户确认登陆，用户确认后返回redirectURL
+// 二维码失效（或推送的登陆被拒绝）时返回错误
+func (wxwb *WechatWeb) pollLogin(ctx context.Context, uuid string, loginChannel chan<- LoginChannelItem) (redirectURL string, body []byte, err error) {
 	tip := "1"
-	for true {
-		redirectURL = func() (redirectURL string) {
-			code, avatar, redirectURL, body, err := wxwb.api.Login(uuid, tip)
-			if err != nil {
-				panic(fatalInfo{
-					"waitForScan",
-					err,
-					body,
-				})
+	for {
+		var code, avatar string
+		code, avatar, redirectURL, body, err = wxwb.currentAPI().LoginContext(ctx, uuid, tip)
+		if err != nil {
+			return "", body, err
+		}
+		tip = "0" // 在第二次轮询的时候tip就为0了
+		switch code {
+		case "200": // 确认登陆
+			wxwb.logger.Info("Login success\n")
+			wxwb.setState(StateInitializing, "login confirmed", nil)
+			loginChannel <- LoginChannelItem{
+				Code: LoginStatusScanedFinish,
 			}
-			tip = "0" // 在第二次轮询的时候tip就为0了
-			switch code {
-			case "200": // 确认登陆
-				wxwb.logger.Info("Login success\n")
-				loginChannel <- LoginChannelItem{
-					Code: LoginStatusScanedFinish,
-				}
-				return redirectURL
-			case "201": // 用户已扫码
-				wxwb.logger.Info("Scan success, waiting for login\n")
-				loginChannel <- LoginChannelItem{
-					Code: LoginStatusScanedWaitForLogin,
-					Msg:  avatar,
-				}
-				return "" // continue
-			case "400": // 登陆失败(二维码失效)
-				err = errors.New("Login fail: qrcode has run out")
-				panic(fatalInfo{
-					"waitForScan",
-					err,
-					body,
-				})
-			case "408": // 等待登陆
-				time.Sleep(500 * time.Microsecond)
-			default:
-				err = errors.New("Login fail: unknown response code: " + code)
-				panic(fatalInfo{
-					"waitForScan",
-					err,
-					body,
-				})
+			return redirectURL, body, nil
+		case "201": // 用户已扫码
+			wxwb.logger.Info("Scan success, waiting for login\n")
+			wxwb.setState(StateAwaitingConfirm, "qrcode scanned", nil)
+			loginChannel <- LoginChannelItem{
+				Code: LoginStatusScanedWaitForLogin,
+				Msg:  avatar,
 			}
-			return
-		}()
-		if redirectURL != "" {
-			break
+		case "400": // 登陆失败(二维码失效)
+			return "", body, errors.New("Login fail: qrcode has run out")
+		case "408": // 等待登陆
+			time.Sleep(500 * time.Microsecond)
+		default:
+			return "", body, errors.New("Login fail: unknown response code: " + code)
 		}
 	}
-	return redirectURL
 }
 
 // 完成登陆,获取登陆凭据
-func (wxwb *WechatWeb) getCookie(redirectURL string, loginChannel chan<- LoginChannelItem) {
-	body, err := wxwb.api.WebwxNewLoginPage(redirectURL)
+func (wxwb *WechatWeb) getCookie(ctx context.Context, redirectURL string, loginChannel chan<- LoginChannelItem) {
+	body, err := wxwb.currentAPI().WebwxNewLoginPageContext(ctx, redirectURL)
 	if err != nil {
 		panic(fatalInfo{
 			"getCookie",
@@ -128,8 +179,8 @@ func (wxwb *WechatWeb) getCookie(redirectURL string, loginChannel chan<- LoginCh
 }
 
 // 初始化微信,获取当前登陆用户\部分联系人
-func (wxwb *WechatWeb) wxInit(loginChannel chan<- LoginChannelItem) {
-	user, contactList, body, err := wxwb.api.WebwxInit()
+func (wxwb *WechatWeb) wxInit(ctx context.Context, loginChannel chan<- LoginChannelItem) {
+	user, contactList, body, err := wxwb.currentAPI().WebwxInitContext(ctx)
 	if err != nil {
 		panic(fatalInfo{
 			"wxInit",
@@ -140,50 +191,97 @@ func (wxwb *WechatWeb) wxInit(loginChannel chan<- LoginChannelItem) {
 	loginChannel <- LoginChannelItem{
 		Code: LoginStatusInitFinish,
 	}
-	wxwb.userInfo.user = user
-	for _, contact := range contactList {
-		wxwb.userInfo.contactList[contact.UserName] = contact
-	}
+	wxwb.setUser(user)
+	wxwb.putContacts(contactList...)
 }
 
 // 获取联系人
 // 注：坑！此处获取到的居然不是完整的联系人，必须和init中获取到的合并后才是完整的联系人列表
-func (wxwb *WechatWeb) getContactList() (err error) {
-	contactList, body, err := wxwb.api.GetContact()
-	if err != nil {
-		wxwb.captureException(err, "GetContact fail", sentry.LevelError, extraData{"body", string(body)})
-		return
-	}
-	for _, contact := range contactList {
-		wxwb.userInfo.contactList[contact.UserName] = contact
+// 联系人较多时服务器会分页返回，每获取到一页通过loginChannel返回一次进度
+func (wxwb *WechatWeb) getContactList(ctx context.Context, loginChannel chan<- LoginChannelItem) (err error) {
+	var seq int64
+	count := 0
+	seen := make(map[int64]bool)
+	for {
+		seen[seq] = true
+		contactList, nextSeq, body, err := wxwb.currentAPI().GetContactPageContext(ctx, seq)
+		if err != nil {
+			wxwb.captureException(err, "GetContact fail", sentry.LevelError, extraData{"body", string(body)}, extraData{"seq", seq})
+			return err
+		}
+		wxwb.putContacts(contactList...)
+		count += len(contactList)
+		loginChannel <- LoginChannelItem{
+			Code: LoginStatusGettingContact,
+			Msg:  strconv.Itoa(count),
+		}
+		if nextSeq == 0 || seen[nextSeq] {
+			// 获取完毕，或服务器返回了重复的seq
+			return nil
+		}
+		seq = nextSeq
 	}
-	return
 }
 
+// batchGetContactChunkSize 每次批量获取的群聊数，超出服务器限制的群聊不会返回MemberList
+const batchGetContactChunkSize = 50
+
 // 获取群聊的成员
-func (wxwb *WechatWeb) batchGetContact() (err error) {
+// 群聊按服务器限制的大小分块后依次获取，请求失败时由api按照重试策略重试
+// 只有所有分块都失败时才返回错误，部分分块失败时只记录日志
+func (wxwb *WechatWeb) batchGetContact(ctx context.Context) (err error) {
 	var itemList []datastruct.BatchGetContactRequestListItem
-	for _, contact := range wxwb.userInfo.contactList {
+	for _, contact := range wxwb.contacts() {
 		if contact.IsChatroom() {
 			itemList = append(itemList, datastruct.BatchGetContactRequestListItem{
 				UserName: contact.UserName,
 			})
 		}
 	}
-	contactList, body, err := wxwb.api.BatchGetContact(itemList)
-	if err != nil {
-		wxwb.captureException(err, "BatchGetContact fail", sentry.LevelError, extraData{"body", string(body)})
-		return
+	if len(itemList) == 0 {
+		return nil
 	}
-	for _, contact := range contactList {
-		if c, ok := wxwb.userInfo.contactList[contact.UserName]; ok {
-			c.MemberCount = contact.MemberCount
-			c.MemberList = contact.MemberList
-			c.EncryChatRoomID = contact.EncryChatRoomID
-			wxwb.userInfo.contactList[c.UserName] = c
+	chunks, failed := 0, 0
+	for len(itemList) > 0 {
+		n := batchGetContactChunkSize
+		if n > len(itemList) {
+			n = len(itemList)
+		}
+		chunk := itemList[:n]
+		itemList = itemList[n:]
+		chunks++
+		// api的登陆信息不能被并发修改，因此分块之间不并发请求
+		contactList, body, chunkErr := wxwb.currentAPI().BatchGetContactContext(ctx, chunk)
+		if chunkErr != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			wxwb.captureException(chunkErr, "BatchGetContact fail", sentry.LevelError, extraData{"body", string(body)})
+			if api.IsLoggedOut(chunkErr) || api.IsSessionInvalid(chunkErr) {
+				// 会话已失效，剩余的分块也不会成功
+				return chunkErr
+			}
+			failed++
+			err = chunkErr
+			continue
+		}
+		for _, contact := range contactList {
+			contact := contact
+			wxwb.updateContact(contact.UserName, func(c datastruct.Contact) datastruct.Contact {
+				c.MemberCount = contact.MemberCount
+				c.MemberList = contact.MemberList
+				c.EncryChatRoomID = contact.EncryChatRoomID
+				return c
+			})
 		}
 	}
-	return
+	if failed == chunks {
+		return err
+	}
+	if failed > 0 {
+		wxwb.logger.Warnf("BatchGetContact %d of %d chunks failed, last error: %v\n", failed, chunks, err)
+	}
+	return nil
 }
 
 type fatalInfo struct {
@@ -195,6 +293,12 @@ type fatalInfo struct {
 // Login 登陆方法总成
 // param loginChannel 登陆状态channel，从中可以读取到登录情况
 func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
+	wxwb.LoginContext(context.Background(), loginChannel)
+}
+
+// LoginContext 登陆方法总成，ctx取消时登陆中止并通过loginChannel返回错误
+// param loginChannel 登陆状态channel，从中可以读取到登录情况
+func (wxwb *WechatWeb) LoginContext(ctx context.Context, loginChannel chan<- LoginChannelItem) {
 	// 尝试使用已存在的登录信息登录
 	go func() {
 		defer close(loginChannel)
@@ -202,6 +306,7 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 			if e := recover(); e != nil {
 				if f, ok := e.(fatalInfo); ok {
 					// 发生了panic
+					wxwb.setState(StateIdle, "login "+f.Msg+" failed", f.Err)
 					wxwb.captureException(f.Err, "Login "+f.Msg+" fatal", sentry.LevelError, extraData{"body", string(f.Body)})
 					loginChannel <- LoginChannelItem{
 						Code: LoginStatusErrorOccurred,
@@ -210,6 +315,7 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 				}
 				if err, ok := e.(error); ok {
 					// 发生了panic
+					wxwb.setState(StateIdle, "login failed", err)
 					wxwb.captureException(err, "Login fatal", sentry.LevelError)
 					loginChannel <- LoginChannelItem{
 						Code: LoginStatusErrorOccurred,
@@ -229,10 +335,11 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 		}
 		if readed {
 			wxwb.logger.Info("loaded stored login info")
-			if wxwb.getContactList() == nil {
+			wxwb.setState(StateReconnecting, "reuse stored login info", nil)
+			if wxwb.getContactList(ctx, loginChannel) == nil {
 				// 获取联系人成功，则为已登陆状态
 				logined = true
-				wxwb.logger.Infof("reuse loginInfo [%s] logined at %v\n", wxwb.userInfo.user.NickName, wxwb.runInfo.LoginAt.Format("2006-01-02 15:04:05"))
+				wxwb.logger.Infof("reuse loginInfo [%s] logined at %v\n", wxwb.currentUser().NickName, wxwb.GetRunInfo().LoginAt.Format("2006-01-02 15:04:05"))
 			}
 		}
 		if !logined {
@@ -240,13 +347,22 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 				// 仅当成功读取了login信息并且登陆失败，才输出此log
 				wxwb.logger.Info("stored login info not avaliable\n")
 			}
+			// 知道上次登陆的Wxuin时先推送登陆，推送需要沿用上次登陆的cookie，因此在重置登陆信息前进行
+			pushUUID := wxwb.pushLogin(ctx)
 			wxwb.resetLoginInfo()
-			uuid := wxwb.getUUID(loginChannel)
-			redirectURL := wxwb.waitForScan(uuid, loginChannel)
-			wxwb.getCookie(redirectURL, loginChannel)
-			wxwb.wxInit(loginChannel)
-			err := wxwb.getContactList()
+			var redirectURL string
+			if pushUUID != "" {
+				redirectURL = wxwb.waitForPushConfirm(ctx, pushUUID, loginChannel)
+			}
+			if redirectURL == "" {
+				uuid := wxwb.getUUID(ctx, loginChannel)
+				redirectURL = wxwb.waitForScan(ctx, uuid, loginChannel)
+			}
+			wxwb.getCookie(ctx, redirectURL, loginChannel)
+			wxwb.wxInit(ctx, loginChannel)
+			err := wxwb.getContactList(ctx, loginChannel)
 			if err != nil {
+				wxwb.setState(StateIdle, "get contact failed", err)
 				loginChannel <- LoginChannelItem{
 					Code: LoginStatusErrorOccurred,
 					Err:  err,
@@ -254,7 +370,9 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 				return
 			}
 			// 此处即认为登陆成功
-			wxwb.runInfo.LoginAt = time.Now()
+			wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+				runInfo.LoginAt = time.Now()
+			})
 		}
 		loginChannel <- LoginChannelItem{
 			Code: LoginStatusGotContact,
@@ -263,8 +381,9 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 		// if err != nil {
 		// 	return errors.New("StatusNotify error: " + err.Error())
 		// }
-		err = wxwb.batchGetContact()
+		err = wxwb.batchGetContact(ctx)
 		if err != nil {
+			wxwb.setState(StateIdle, "batch get contact failed", err)
 			loginChannel <- LoginChannelItem{
 				Code: LoginStatusErrorOccurred,
 				Err:  err,
@@ -274,26 +393,43 @@ func (wxwb *WechatWeb) Login(loginChannel chan<- LoginChannelItem) {
 		loginChannel <- LoginChannelItem{
 			Code: LoginStatusBatchGotContact,
 		}
-		wxwb.logger.Infof("User %s has Login Success, total %d contacts\n", wxwb.userInfo.user.NickName, len(wxwb.userInfo.contactList))
+		wxwb.logger.Infof("User %s has Login Success, total %d contacts\n", wxwb.currentUser().NickName, len(wxwb.contacts()))
+		wxwb.setState(StateOnline, "login success", nil)
 		// 如有必要，记录login信息到storer
 		wxwb.writeLoginInfo()
+		// 重新登陆时先结束上一次登陆留下的协程
+		wxwb.stopLoginNotify()
 		notifyChan := make(chan bool)
-		wxwb.api.SetLoginModifyNotifyChan(notifyChan)
+		notifyStop := make(chan struct{})
+		wxwb.notifyStop = notifyStop
+		wxwb.currentAPI().SetLoginModifyNotifyChan(notifyChan)
 		// 新建协程用于检测登陆信息修改，如果检测到修改则保存登陆信息
-		go func(notifyChan <-chan bool) {
-			// 检测到修改，保存
-			for range notifyChan {
-				wxwb.writeLoginInfo()
+		go func(notifyChan <-chan bool, notifyStop <-chan struct{}) {
+			for {
+				select {
+				case <-notifyChan:
+					// 检测到修改，保存
+					wxwb.writeLoginInfo()
+				case <-notifyStop:
+					return
+				}
 			}
-		}(notifyChan)
+		}(notifyChan, notifyStop)
 	}()
 }
 
 // Logout 退出登录
 func (wxwb *WechatWeb) Logout() (err error) {
-	body, err := wxwb.api.Logout()
+	return wxwb.LogoutContext(context.Background())
+}
+
+// LogoutContext 退出登录
+func (wxwb *WechatWeb) LogoutContext(ctx context.Context) (err error) {
+	body, err := wxwb.currentAPI().LogoutContext(ctx)
 	if err != nil {
 		wxwb.captureException(err, "Logout fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
 	}
+	wxwb.setState(StateLoggedOut, "logout", nil)
 	return
 }
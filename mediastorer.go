@@ -1,7 +1,10 @@
 package wwdk
 
 import (
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 )
@@ -22,6 +25,8 @@ const (
 	MediaTypeMessageVoice MediaType = 12
 	// MediaTypeMessageVideo 信息视频媒体类型
 	MediaTypeMessageVideo MediaType = 13
+	// MediaTypeMessageFile 信息附件媒体类型
+	MediaTypeMessageFile MediaType = 14
 )
 
 // MediaFile 媒体文件
@@ -34,12 +39,34 @@ type MediaFile struct {
 	BinaryContent []byte
 }
 
+// MediaStreamFile 以流的形式提供内容的媒体文件
+type MediaStreamFile struct {
+	// MediaType 媒体类型
+	MediaType MediaType
+	// FileName 文件名
+	FileName string
+	// Content 文件内容，由调用方负责关闭
+	Content io.Reader
+	// ContentLength 内容长度，未知时为-1
+	ContentLength int64
+	// ContentType 内容的mime类型
+	ContentType string
+}
+
 // MediaStorer 媒体文件储存器
 type MediaStorer interface {
 	// Storer 储存媒体文件，传入媒体文件，返回媒体文件URL与err异常
 	Storer(file MediaFile) (url string, err error)
 }
 
+// MediaStreamStorer 支持流式储存的媒体文件储存器
+// 如果MediaStorer同时实现了此接口，则下载媒体文件时不会将文件整个读入内存
+type MediaStreamStorer interface {
+	MediaStorer
+	// StreamStorer 储存媒体文件，传入流形式的媒体文件，返回媒体文件URL与err异常
+	StreamStorer(file MediaStreamFile) (url string, err error)
+}
+
 // localMediaStorer 内置的媒体储存器，将媒体文件储存到当前文件夹下
 type localMediaStorer struct {
 	saveDir string
@@ -66,3 +93,30 @@ func (s *localMediaStorer) Storer(file MediaFile) (url string, err error) {
 	}
 	return filename, nil
 }
+
+// StreamStorer 以流的方式储存媒体文件
+func (s *localMediaStorer) StreamStorer(file MediaStreamFile) (url string, err error) {
+	filename := s.saveDir + file.FileName
+	// 先写入同目录下的临时文件，写入完成后再重命名，避免下载中断时留下不完整的文件
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return "", errors.New("create temp file for " + filename + " error: " + err.Error())
+	}
+	tmpName := f.Name()
+	// TempFile创建的文件权限为0600，改为与os.Create一致的普通权限
+	f.Chmod(0644)
+	_, err = io.Copy(f, file.Content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return "", errors.New("Write to file error: " + err.Error())
+	}
+	err = os.Rename(tmpName, filename)
+	if err != nil {
+		os.Remove(tmpName)
+		return "", errors.New("rename " + tmpName + " error: " + err.Error())
+	}
+	return filename, nil
+}
@@ -32,11 +32,15 @@ func (wxwb *WechatWeb) messageProcesser(msg *datastruct.Message, syncChannel cha
 	// 收到其他消息：解码Content后再放入channel
 	switch msg.MsgType {
 	case datastruct.TextMsg:
-		wxwb.runInfo.MessageRecivedCount++
+		wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+			runInfo.MessageRecivedCount++
+		})
 		syncChannel <- SyncChannelItem{
 			Code:    SyncStatusNewMessage,
 			Message: msg,
 		}
+	case datastruct.VerifyMsg:
+		fallthrough
 	case datastruct.ImageMsg:
 		fallthrough
 	case datastruct.AnimationEmotionsMsg:
@@ -44,14 +48,33 @@ func (wxwb *WechatWeb) messageProcesser(msg *datastruct.Message, syncChannel cha
 	case datastruct.LittleVideoMsg:
 		fallthrough
 	case datastruct.VoiceMsg:
-		wxwb.runInfo.MessageRecivedCount++
+		wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+			runInfo.MessageRecivedCount++
+		})
+		msg.Content = strings.Replace(html.UnescapeString(msg.Content), "<br/>", "", -1)
+		syncChannel <- SyncChannelItem{
+			Code:    SyncStatusNewMessage,
+			Message: msg,
+		}
+	case datastruct.LinkMsg:
+		if msg.AppMsgType != datastruct.ReciveFileAppmsg {
+			wxwb.captureException(nil, "Unknown AppMsgType", sentry.LevelWarning, extraData{"appMsgType", msg.AppMsgType}, extraData{"msg", msg})
+			wxwb.logger.Infof("Unknown AppMsgType %v: %#v", msg.AppMsgType, msg)
+			break
+		}
+		// 收到文件
+		wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+			runInfo.MessageRecivedCount++
+		})
 		msg.Content = strings.Replace(html.UnescapeString(msg.Content), "<br/>", "", -1)
 		syncChannel <- SyncChannelItem{
 			Code:    SyncStatusNewMessage,
 			Message: msg,
 		}
 	case datastruct.RevokeMsg:
-		wxwb.runInfo.MessageRevokeRecivedCount++
+		wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+			runInfo.MessageRevokeRecivedCount++
+		})
 		msg.Content = strings.Replace(html.UnescapeString(msg.Content), "<br/>", "", -1)
 		syncChannel <- SyncChannelItem{
 			Code:    SyncStatusNewMessage,
@@ -0,0 +1,183 @@
+package wwdk
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSendRateLimited 错误：发送过快，在ctx的截止时间前无法获取到发送配额
+	ErrSendRateLimited = errors.New("send rate limited")
+)
+
+// SendLimit 发送消息的限速配置，使用令牌桶算法
+// 速率为每秒允许发送的消息数，小于等于0时不限制
+type SendLimit struct {
+	// GlobalRate 所有联系人共享的发送速率
+	GlobalRate float64
+	// GlobalBurst 所有联系人共享的突发发送数
+	GlobalBurst int
+	// RecipientRate 向同一个联系人发送的速率
+	RecipientRate float64
+	// RecipientBurst 向同一个联系人的突发发送数
+	RecipientBurst int
+	// ChatroomRate 向同一个群聊发送的速率，群聊更容易触发限制，应当比RecipientRate更低
+	ChatroomRate float64
+	// ChatroomBurst 向同一个群聊的突发发送数
+	ChatroomBurst int
+}
+
+// DefaultSendLimit 默认的发送限速
+func DefaultSendLimit() *SendLimit {
+	return &SendLimit{
+		GlobalRate:     1,
+		GlobalBurst:    5,
+		RecipientRate:  0.5,
+		RecipientBurst: 3,
+		ChatroomRate:   0.2,
+		ChatroomBurst:  2,
+	}
+}
+
+// tokenBucket 令牌桶，令牌数可以为负，表示已经被预订
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建装满令牌的令牌桶，rate小于等于0时返回nil表示不限制
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// advance 根据流逝的时间补充令牌
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// delay 获取一个令牌需要等待的时间
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.advance(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// sendLimiter 发送限速器
+type sendLimiter struct {
+	limit      SendLimit
+	mu         sync.Mutex
+	global     *tokenBucket
+	recipients map[string]*tokenBucket
+}
+
+// newSendLimiter 创建发送限速器
+func newSendLimiter(limit SendLimit) *sendLimiter {
+	return &sendLimiter{
+		limit:      limit,
+		global:     newTokenBucket(limit.GlobalRate, limit.GlobalBurst, time.Now()),
+		recipients: make(map[string]*tokenBucket),
+	}
+}
+
+// recipientBucket 获取联系人的令牌桶，调用时必须持有锁
+func (l *sendLimiter) recipientBucket(toUserName string, now time.Time) *tokenBucket {
+	if b, ok := l.recipients[toUserName]; ok {
+		return b
+	}
+	var b *tokenBucket
+	if strings.HasPrefix(toUserName, "@@") {
+		b = newTokenBucket(l.limit.ChatroomRate, l.limit.ChatroomBurst, now)
+	} else {
+		b = newTokenBucket(l.limit.RecipientRate, l.limit.RecipientBurst, now)
+	}
+	if b == nil {
+		return nil
+	}
+	if len(l.recipients) >= 1024 {
+		// 清理已经装满（长时间未发送）的令牌桶，防止无限增长
+		for userName, rb := range l.recipients {
+			rb.advance(now)
+			if rb.tokens >= rb.burst {
+				delete(l.recipients, userName)
+			}
+		}
+	}
+	l.recipients[toUserName] = b
+	return b
+}
+
+// wait 等待直到可以向联系人发送消息
+// 如果ctx设置了截止时间且在截止时间前无法获取到配额，则立即返回ErrSendRateLimited
+// @param toUserName 要发送的目标联系人的UserName
+func (l *sendLimiter) wait(ctx context.Context, toUserName string) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	var buckets []*tokenBucket
+	var delay time.Duration
+	for _, b := range []*tokenBucket{l.global, l.recipientBucket(toUserName, now)} {
+		if b == nil {
+			continue
+		}
+		buckets = append(buckets, b)
+		if d := b.delay(now); d > delay {
+			delay = d
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		l.mu.Unlock()
+		return errors.Wrapf(ErrSendRateLimited, "need wait %v", delay)
+	}
+	// 预订令牌
+	for _, b := range buckets {
+		b.tokens--
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// 取消预订，归还令牌
+		l.mu.Lock()
+		for _, b := range buckets {
+			b.tokens++
+			if b.tokens > b.burst {
+				b.tokens = b.burst
+			}
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -1,23 +1,42 @@
 package wwdk
 
 import (
+	"context"
 	"github.com/getsentry/sentry-go"
+	"github.com/ikuiki/wwdk/api"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path/filepath"
 
 	"github.com/ikuiki/wwdk/datastruct"
 )
 
-// SaveMessageImage 保存消息图片到指定位置
-func (wxwb *WechatWeb) SaveMessageImage(msg datastruct.Message) (filename string, err error) {
-	d, err := wxwb.api.SaveMessageImage(msg.MsgID)
-	if err != nil {
-		wxwb.captureException(err, "SaveMessageImage fatal", sentry.LevelError)
-		return
+// storeMediaStream 将媒体文件流储存到mediaStorer中
+// 如果mediaStorer支持流式储存则直接传入流，否则读入内存后储存
+func (wxwb *WechatWeb) storeMediaStream(stream *api.MediaStream, mediaType MediaType, fileName string) (filename string, err error) {
+	defer stream.Close()
+	if streamStorer, ok := wxwb.mediaStorer.(MediaStreamStorer); ok {
+		filename, err = streamStorer.StreamStorer(MediaStreamFile{
+			MediaType:     mediaType,
+			FileName:      fileName,
+			Content:       stream,
+			ContentLength: stream.ContentLength,
+			ContentType:   stream.ContentType,
+		})
+	} else {
+		var d []byte
+		d, err = ioutil.ReadAll(stream)
+		if err != nil {
+			err = errors.WithStack(err)
+			wxwb.captureException(err, "Read media stream fatal", sentry.LevelError)
+			return
+		}
+		filename, err = wxwb.mediaStorer.Storer(MediaFile{
+			MediaType:     mediaType,
+			FileName:      fileName,
+			BinaryContent: d,
+		})
 	}
-	filename, err = wxwb.mediaStorer.Storer(MediaFile{
-		MediaType:     MediaTypeMessageImage,
-		FileName:      msg.MsgID + ".png",
-		BinaryContent: d,
-	})
 	if err != nil {
 		wxwb.captureException(err, "MediaStorer.Storer fatal", sentry.LevelError)
 		return
@@ -25,98 +44,125 @@ func (wxwb *WechatWeb) SaveMessageImage(msg datastruct.Message) (filename string
 	return filename, nil
 }
 
-// SaveMessageVoice 保存消息声音到指定位置
-func (wxwb *WechatWeb) SaveMessageVoice(msg datastruct.Message) (filename string, err error) {
-	d, err := wxwb.api.SaveMessageVoice(msg.MsgID)
+// StreamMessageImageContext 以流的方式获取消息图片，使用完毕后必须Close
+func (wxwb *WechatWeb) StreamMessageImageContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error) {
+	stream, err = wxwb.currentAPI().StreamMessageImageContext(ctx, msg.MsgID)
 	if err != nil {
-		wxwb.captureException(err, "SaveMessageVoice fatal", sentry.LevelError)
-		return
+		wxwb.captureException(err, "StreamMessageImage fatal", sentry.LevelError)
 	}
-	filename, err = wxwb.mediaStorer.Storer(MediaFile{
-		MediaType:     MediaTypeMessageVoice,
-		FileName:      msg.MsgID + ".mp3",
-		BinaryContent: d,
-	})
+	return
+}
+
+// SaveMessageImageContext 保存消息图片到指定位置
+func (wxwb *WechatWeb) SaveMessageImageContext(ctx context.Context, msg datastruct.Message) (filename string, err error) {
+	stream, err := wxwb.StreamMessageImageContext(ctx, msg)
 	if err != nil {
-		wxwb.captureException(err, "MediaStorer.Storer fatal", sentry.LevelError)
 		return
 	}
-	return filename, nil
+	return wxwb.storeMediaStream(stream, MediaTypeMessageImage, msg.MsgID+".png")
 }
 
-// SaveMessageVideo 保存消息视频到指定位置
-func (wxwb *WechatWeb) SaveMessageVideo(msg datastruct.Message) (filename string, err error) {
-	d, err := wxwb.api.SaveMessageVideo(msg.MsgID)
+// StreamMessageVoiceContext 以流的方式获取消息声音，使用完毕后必须Close
+func (wxwb *WechatWeb) StreamMessageVoiceContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error) {
+	stream, err = wxwb.currentAPI().StreamMessageVoiceContext(ctx, msg.MsgID)
 	if err != nil {
-		wxwb.captureException(err, "SaveMessageVideo fatal", sentry.LevelError)
-		return
+		wxwb.captureException(err, "StreamMessageVoice fatal", sentry.LevelError)
 	}
-	filename, err = wxwb.mediaStorer.Storer(MediaFile{
-		MediaType:     MediaTypeMessageVideo,
-		FileName:      msg.MsgID + ".mp4",
-		BinaryContent: d,
-	})
+	return
+}
+
+// SaveMessageVoiceContext 保存消息声音到指定位置
+func (wxwb *WechatWeb) SaveMessageVoiceContext(ctx context.Context, msg datastruct.Message) (filename string, err error) {
+	stream, err := wxwb.StreamMessageVoiceContext(ctx, msg)
 	if err != nil {
-		wxwb.captureException(err, "MediaStorer.Storer fatal", sentry.LevelError)
 		return
 	}
-	return filename, nil
+	return wxwb.storeMediaStream(stream, MediaTypeMessageVoice, msg.MsgID+".mp3")
 }
 
-// SaveContactImg 保存联系人头像
-func (wxwb *WechatWeb) SaveContactImg(contact datastruct.Contact) (filename string, err error) {
-	d, err := wxwb.api.SaveContactImg(contact.HeadImgURL)
+// StreamMessageVideoContext 以流的方式获取消息视频，使用完毕后必须Close
+func (wxwb *WechatWeb) StreamMessageVideoContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error) {
+	stream, err = wxwb.currentAPI().StreamMessageVideoContext(ctx, msg.MsgID)
 	if err != nil {
-		wxwb.captureException(err, "SaveContactImg fatal", sentry.LevelError)
-		return
+		wxwb.captureException(err, "StreamMessageVideo fatal", sentry.LevelError)
 	}
-	filename, err = wxwb.mediaStorer.Storer(MediaFile{
-		MediaType:     MediaTypeContactHeadImg,
-		FileName:      contact.UserName + ".png",
-		BinaryContent: d,
-	})
+	return
+}
+
+// SaveMessageVideoContext 保存消息视频到指定位置
+func (wxwb *WechatWeb) SaveMessageVideoContext(ctx context.Context, msg datastruct.Message) (filename string, err error) {
+	stream, err := wxwb.StreamMessageVideoContext(ctx, msg)
 	if err != nil {
-		wxwb.captureException(err, "MediaStorer.Storer fatal", sentry.LevelError)
 		return
 	}
-	return filename, nil
+	return wxwb.storeMediaStream(stream, MediaTypeMessageVideo, msg.MsgID+".mp4")
 }
 
-// SaveUserImg 保存登陆用户的头像
-func (wxwb *WechatWeb) SaveUserImg(user datastruct.User) (filename string, err error) {
-	d, err := wxwb.api.SaveContactImg(user.HeadImgURL)
+// StreamMessageFileContext 以流的方式获取消息附件，使用完毕后必须Close
+func (wxwb *WechatWeb) StreamMessageFileContext(ctx context.Context, msg datastruct.Message) (stream *api.MediaStream, err error) {
+	if msg.MsgType != datastruct.LinkMsg || msg.AppMsgType != datastruct.ReciveFileAppmsg {
+		err = errors.Errorf("message %s is not a file message", msg.MsgID)
+		return
+	}
+	stream, err = wxwb.currentAPI().StreamMessageFileContext(ctx, msg.MediaID, msg.FileName, msg.FromUserName)
+	if err != nil {
+		wxwb.captureException(err, "StreamMessageFile fatal", sentry.LevelError)
+	}
+	return
+}
+
+// SaveMessageFileContext 保存消息附件到指定位置
+func (wxwb *WechatWeb) SaveMessageFileContext(ctx context.Context, msg datastruct.Message) (filename string, err error) {
+	stream, err := wxwb.StreamMessageFileContext(ctx, msg)
 	if err != nil {
-		wxwb.captureException(err, "SaveUserImg fatal", sentry.LevelError)
 		return
 	}
-	filename, err = wxwb.mediaStorer.Storer(MediaFile{
-		MediaType:     MediaTypeUserHeadImg,
-		FileName:      user.UserName + ".png",
-		BinaryContent: d,
-	})
+	return wxwb.storeMediaStream(stream, MediaTypeMessageFile, msg.MsgID+"_"+filepath.Base(msg.FileName))
+}
+
+// StreamContactImgContext 以流的方式获取联系人头像，使用完毕后必须Close
+func (wxwb *WechatWeb) StreamContactImgContext(ctx context.Context, contact datastruct.Contact) (stream *api.MediaStream, err error) {
+	stream, err = wxwb.currentAPI().StreamContactImgContext(ctx, contact.HeadImgURL)
+	if err != nil {
+		wxwb.captureException(err, "StreamContactImg fatal", sentry.LevelError)
+	}
+	return
+}
+
+// SaveContactImgContext 保存联系人头像
+func (wxwb *WechatWeb) SaveContactImgContext(ctx context.Context, contact datastruct.Contact) (filename string, err error) {
+	stream, err := wxwb.StreamContactImgContext(ctx, contact)
 	if err != nil {
-		wxwb.captureException(err, "MediaStorer.Storer fatal", sentry.LevelError)
 		return
 	}
-	return filename, nil
+	return wxwb.storeMediaStream(stream, MediaTypeContactHeadImg, contact.UserName+".png")
 }
 
-// SaveMemberImg 保存群成员的头像
-// TODO: delete
-func (wxwb *WechatWeb) SaveMemberImg(member datastruct.Member, chatroomID string) (filename string, err error) {
-	d, err := wxwb.api.SaveMemberImg(member.UserName, chatroomID)
+// SaveUserImgContext 保存登陆用户的头像
+func (wxwb *WechatWeb) SaveUserImgContext(ctx context.Context, user datastruct.User) (filename string, err error) {
+	stream, err := wxwb.currentAPI().StreamContactImgContext(ctx, user.HeadImgURL)
 	if err != nil {
-		wxwb.captureException(err, "SaveMemberImg fatal", sentry.LevelError)
+		wxwb.captureException(err, "SaveUserImg fatal", sentry.LevelError)
 		return
 	}
-	filename, err = wxwb.mediaStorer.Storer(MediaFile{
-		MediaType:     MediaTypeMemberHeadImg,
-		FileName:      member.UserName + ".png",
-		BinaryContent: d,
-	})
+	return wxwb.storeMediaStream(stream, MediaTypeUserHeadImg, user.UserName+".png")
+}
+
+// StreamMemberImgContext 以流的方式获取群成员的头像，使用完毕后必须Close
+func (wxwb *WechatWeb) StreamMemberImgContext(ctx context.Context, member datastruct.Member, chatroomID string) (stream *api.MediaStream, err error) {
+	stream, err = wxwb.currentAPI().StreamMemberImgContext(ctx, member.UserName, chatroomID)
+	if err != nil {
+		wxwb.captureException(err, "StreamMemberImg fatal", sentry.LevelError)
+	}
+	return
+}
+
+// SaveMemberImgContext 保存群成员的头像
+// TODO: delete
+func (wxwb *WechatWeb) SaveMemberImgContext(ctx context.Context, member datastruct.Member, chatroomID string) (filename string, err error) {
+	stream, err := wxwb.StreamMemberImgContext(ctx, member, chatroomID)
 	if err != nil {
-		wxwb.captureException(err, "MediaStorer.Storer fatal", sentry.LevelError)
 		return
 	}
-	return filename, nil
+	return wxwb.storeMediaStream(stream, MediaTypeMemberHeadImg, member.UserName+".png")
 }
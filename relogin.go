@@ -0,0 +1,70 @@
+package wwdk
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AutoRelogin 自动重新登陆的配置，作为配置传入NewWechatWeb后开启
+// 同步时发现用户登出或会话失效后，不再退出同步，而是重新执行登陆流程：
+// 先尝试使用storer中保存的登陆信息恢复会话，失败后向手机推送登陆确认（需要之前登陆过），
+// 推送失败或在超时前未确认时再重新扫码登陆
+// 登陆的进度通过syncChannel以SyncStatusRelogin返回（需要扫码时可以从中获取二维码url），
+// 登陆成功后同步在原来的syncChannel上继续进行
+// 通过Logout主动退出登陆时不会自动重新登陆
+type AutoRelogin struct {
+	// MaxAttempts 连续重新登陆失败的最大次数，超过后同步退出并返回SyncStatusPanic，小于等于0时不限制
+	MaxAttempts int
+}
+
+// relogin 重新登陆，登陆进度通过syncChannel返回
+// 登陆失败时按照重试策略退避后重试，直到登陆成功、超过最大次数或ctx取消
+func (wxwb *WechatWeb) relogin(ctx context.Context, syncChannel chan<- SyncChannelItem) (err error) {
+	for attempt := 1; ; attempt++ {
+		wxwb.logger.Infof("Relogin attempt %d\n", attempt)
+		err = wxwb.reloginOnce(ctx, syncChannel)
+		if err == nil {
+			wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+				runInfo.ReloginCount++
+			})
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if wxwb.autoRelogin.MaxAttempts > 0 && attempt >= wxwb.autoRelogin.MaxAttempts {
+			return errors.Wrapf(err, "relogin failed after %d attempts", attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wxwb.retryPolicy.Backoff(attempt)):
+		}
+	}
+}
+
+// reloginOnce 执行一次登陆流程，并将登陆进度转发到syncChannel
+func (wxwb *WechatWeb) reloginOnce(ctx context.Context, syncChannel chan<- SyncChannelItem) (err error) {
+	loginChannel := make(chan LoginChannelItem)
+	wxwb.LoginContext(ctx, loginChannel)
+	logined := false
+	for item := range loginChannel {
+		item := item
+		switch item.Code {
+		case LoginStatusErrorOccurred:
+			err = item.Err
+		case LoginStatusBatchGotContact:
+			logined = true
+		}
+		syncChannel <- SyncChannelItem{
+			Code:  SyncStatusRelogin,
+			Login: &item,
+		}
+	}
+	if err == nil && !logined {
+		err = errors.New("login exited without success")
+	}
+	return
+}
@@ -1,12 +1,24 @@
 package wwdk
 
 import (
+	"bytes"
+	"context"
 	"github.com/getsentry/sentry-go"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 )
 
-// StatusNotify 消息已读通知
-func (wxwb *WechatWeb) StatusNotify(toUserName string, code int64) (err error) {
-	body, err := wxwb.api.StatusNotify(wxwb.userInfo.user.UserName, toUserName, code)
+// StatusNotifyContext 消息已读通知
+func (wxwb *WechatWeb) StatusNotifyContext(ctx context.Context, toUserName string, code int64) (err error) {
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	body, err := wxwb.currentAPI().StatusNotifyContext(ctx, fromUserName, toUserName, code)
 	if err != nil {
 		wxwb.captureException(err, "fatal", sentry.LevelError, extraData{"body", string(body)})
 		return
@@ -14,33 +26,224 @@ func (wxwb *WechatWeb) StatusNotify(toUserName string, code int64) (err error) {
 	return
 }
 
-// SendTextMessage 发送消息
-func (wxwb *WechatWeb) SendTextMessage(toUserName, content string) (msgID, localID string, err error) {
-	msgID, localID, body, err := wxwb.api.SendTextMessage(wxwb.userInfo.user.UserName, toUserName, content)
+// SendTextMessageContext 发送消息
+func (wxwb *WechatWeb) SendTextMessageContext(ctx context.Context, toUserName, content string) (msgID, localID string, err error) {
+	err = wxwb.sendLimiter.wait(ctx, toUserName)
+	if err != nil {
+		return
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	msgID, localID, body, err := wxwb.currentAPI().SendTextMessageContext(ctx, fromUserName, toUserName, content)
 	if err != nil {
 		wxwb.captureException(err, "SendTextMessage fatal", sentry.LevelError, extraData{"body", string(body)})
 		return
 	}
-	wxwb.runInfo.MessageCount++
-	wxwb.runInfo.MessageSentCount++
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageCount++
+		runInfo.MessageSentCount++
+	})
 	return
 }
 
-// SendRevokeMessage 撤回消息
-func (wxwb *WechatWeb) SendRevokeMessage(svrMsgID, clientMsgID, toUserName string) (err error) {
-	body, err := wxwb.api.SendRevokeMessage(toUserName, svrMsgID, clientMsgID)
+// uploadMedia 上传媒体文件，返回MediaID与文件大小
+// 如果传入的file不支持Seek，则会先将其读入内存
+func (wxwb *WechatWeb) uploadMedia(ctx context.Context, toUserName, fileName string, file io.Reader) (mediaID string, size int64, err error) {
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return "", 0, errors.WithStack(err)
+		}
+		seeker = bytes.NewReader(data)
+	}
+	size, err = seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+	_, err = seeker.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	mediaID, body, err := wxwb.currentAPI().UploadMediaContext(ctx, fromUserName, toUserName, fileName, seeker)
+	if err != nil {
+		wxwb.captureException(err, "UploadMedia fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	return
+}
+
+// SendImageMessageContext 发送图片消息
+// @param toUserName 要发送的目标联系人的UserName
+// @param fileName 图片的文件名，会根据后缀判断图片类型
+// @param file 图片内容
+func (wxwb *WechatWeb) SendImageMessageContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	err = wxwb.sendLimiter.wait(ctx, toUserName)
+	if err != nil {
+		return
+	}
+	mediaID, _, err := wxwb.uploadMedia(ctx, toUserName, fileName, file)
+	if err != nil {
+		return
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	msgID, localID, body, err := wxwb.currentAPI().SendImageMessageContext(ctx, fromUserName, toUserName, mediaID)
+	if err != nil {
+		wxwb.captureException(err, "SendImageMessage fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageCount++
+		runInfo.MessageSentCount++
+	})
+	return
+}
+
+// SendVideoMessageContext 发送视频消息
+// 返回的msgID与localID可用于撤回消息
+// @param toUserName 要发送的目标联系人的UserName
+// @param fileName 视频的文件名，仅支持mp4格式
+// @param file 视频内容
+func (wxwb *WechatWeb) SendVideoMessageContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	if strings.ToLower(filepath.Ext(fileName)) != ".mp4" {
+		err = errors.Errorf("unsupported video file %s: only mp4 is supported", fileName)
+		return
+	}
+	err = wxwb.sendLimiter.wait(ctx, toUserName)
+	if err != nil {
+		return
+	}
+	mediaID, _, err := wxwb.uploadMedia(ctx, toUserName, fileName, file)
+	if err != nil {
+		return
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	msgID, localID, body, err := wxwb.currentAPI().SendVideoMessageContext(ctx, fromUserName, toUserName, mediaID)
+	if err != nil {
+		wxwb.captureException(err, "SendVideoMessage fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageCount++
+		runInfo.MessageSentCount++
+	})
+	return
+}
+
+// SendFileMessageContext 发送文件消息
+// 文件会以appmsg(type=6)附件的形式发送
+// @param toUserName 要发送的目标联系人的UserName
+// @param fileName 文件名，对方收到的附件即为此文件名
+// @param file 文件内容
+func (wxwb *WechatWeb) SendFileMessageContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	err = wxwb.sendLimiter.wait(ctx, toUserName)
+	if err != nil {
+		return
+	}
+	mediaID, size, err := wxwb.uploadMedia(ctx, toUserName, fileName, file)
+	if err != nil {
+		return
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	msgID, localID, body, err := wxwb.currentAPI().SendFileMessageContext(ctx, fromUserName, toUserName, mediaID, fileName, size)
+	if err != nil {
+		wxwb.captureException(err, "SendFileMessage fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageCount++
+		runInfo.MessageSentCount++
+	})
+	return
+}
+
+// SendEmoticonContext 发送动图消息
+// 将gif上传后以动图的形式发送
+// @param toUserName 要发送的目标联系人的UserName
+// @param fileName 动图的文件名
+// @param file 动图内容
+func (wxwb *WechatWeb) SendEmoticonContext(ctx context.Context, toUserName, fileName string, file io.Reader) (msgID, localID string, err error) {
+	err = wxwb.sendLimiter.wait(ctx, toUserName)
+	if err != nil {
+		return
+	}
+	mediaID, _, err := wxwb.uploadMedia(ctx, toUserName, fileName, file)
+	if err != nil {
+		return
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	msgID, localID, body, err := wxwb.currentAPI().SendEmoticonMessageContext(ctx, fromUserName, toUserName, mediaID, "")
+	if err != nil {
+		wxwb.captureException(err, "SendEmoticon fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageCount++
+		runInfo.MessageSentCount++
+	})
+	return
+}
+
+// SendEmoticonByMd5Context 根据md5发送动图消息
+// 收到的动图消息的Content解析为appmsg.EmotionMsgContent后，其中Emoji.Md5即为此处的md5
+// @param toUserName 要发送的目标联系人的UserName
+// @param emoticonMd5 动图的md5
+func (wxwb *WechatWeb) SendEmoticonByMd5Context(ctx context.Context, toUserName, emoticonMd5 string) (msgID, localID string, err error) {
+	err = wxwb.sendLimiter.wait(ctx, toUserName)
+	if err != nil {
+		return
+	}
+	fromUserName, err := wxwb.selfUserName()
+	if err != nil {
+		return
+	}
+	msgID, localID, body, err := wxwb.currentAPI().SendEmoticonMessageContext(ctx, fromUserName, toUserName, "", emoticonMd5)
+	if err != nil {
+		wxwb.captureException(err, "SendEmoticonByMd5 fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageCount++
+		runInfo.MessageSentCount++
+	})
+	return
+}
+
+// SendRevokeMessageContext 撤回消息
+func (wxwb *WechatWeb) SendRevokeMessageContext(ctx context.Context, svrMsgID, clientMsgID, toUserName string) (err error) {
+	body, err := wxwb.currentAPI().SendRevokeMessageContext(ctx, toUserName, svrMsgID, clientMsgID)
 	if err != nil {
 		wxwb.captureException(err, "SendRevokeMessage fatal", sentry.LevelError, extraData{"body", string(body)})
 		return
 	}
-	wxwb.runInfo.MessageRevokeCount++
-	wxwb.runInfo.MessageRevokeSentCount++
+	wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+		runInfo.MessageRevokeCount++
+		runInfo.MessageRevokeSentCount++
+	})
 	return
 }
 
-// ModifyUserRemakName 修改用户备注
-func (wxwb *WechatWeb) ModifyUserRemakName(userName, remarkName string) (err error) {
-	body, err := wxwb.api.ModifyUserRemakName(userName, remarkName)
+// ModifyUserRemakNameContext 修改用户备注
+func (wxwb *WechatWeb) ModifyUserRemakNameContext(ctx context.Context, userName, remarkName string) (err error) {
+	body, err := wxwb.currentAPI().ModifyUserRemakNameContext(ctx, userName, remarkName)
 	if err != nil {
 		wxwb.captureException(err, "ModifyUserRemakName fatal", sentry.LevelError, extraData{"body", string(body)})
 		return
@@ -48,9 +251,27 @@ func (wxwb *WechatWeb) ModifyUserRemakName(userName, remarkName string) (err err
 	return
 }
 
-// ModifyChatRoomTopic 修改群名
-func (wxwb *WechatWeb) ModifyChatRoomTopic(userName, newTopic string) (err error) {
-	body, err := wxwb.api.ModifyChatRoomTopic(userName, newTopic)
+// AcceptFriendRequestContext 接受好友请求
+// @param msg 收到的好友请求消息(MsgType=37)
+func (wxwb *WechatWeb) AcceptFriendRequestContext(ctx context.Context, msg datastruct.Message) (err error) {
+	if msg.MsgType != datastruct.VerifyMsg || msg.RecommendInfo == nil {
+		err = errors.Errorf("message %s is not a friend request", msg.MsgID)
+		return
+	}
+	userName := msg.RecommendInfo.UserName
+	body, err := wxwb.currentAPI().VerifyUserContext(ctx, datastruct.VerifyUserOpcodeAccept, userName, msg.RecommendInfo.Ticket)
+	if err != nil {
+		wxwb.captureException(err, "AcceptFriendRequest fatal", sentry.LevelError, extraData{"body", string(body)})
+		return
+	}
+	// 接受成功后获取新联系人的信息并更新到联系人列表中
+	_, err = wxwb.refreshContact(ctx, userName)
+	return
+}
+
+// ModifyChatRoomTopicContext 修改群名
+func (wxwb *WechatWeb) ModifyChatRoomTopicContext(ctx context.Context, userName, newTopic string) (err error) {
+	body, err := wxwb.currentAPI().ModifyChatRoomTopicContext(ctx, userName, newTopic)
 	if err != nil {
 		wxwb.captureException(err, "ModifyChatRoomTopic fatal", sentry.LevelError, extraData{"body", string(body)})
 		return
@@ -38,16 +38,17 @@ func (wwdk *WechatWeb) captureException(err error, errType string, level sentry.
 			Stacktrace: stacktrace,
 		}}
 	}
-	if wwdk.userInfo.user != nil {
-		event.User.ID = wwdk.userInfo.user.UserName
-		event.User.Username = wwdk.userInfo.user.NickName
-		event.Extra["wwdk_login_at"] = wwdk.runInfo.LoginAt
+	runInfo := wwdk.GetRunInfo()
+	if user := wwdk.currentUser(); user != nil {
+		event.User.ID = user.UserName
+		event.User.Username = user.NickName
+		event.Extra["wwdk_login_at"] = runInfo.LoginAt
 	}
-	event.Extra["wwdk_StartAt"] = wwdk.runInfo.StartAt
-	event.Extra["wwdk_SyncCount"] = wwdk.runInfo.SyncCount
-	event.Extra["wwdk_ContactModifyCount"] = wwdk.runInfo.ContactModifyCount
-	event.Extra["wwdk_MessageCount"] = wwdk.runInfo.MessageCount
-	event.Extra["wwdk_PanicCount"] = wwdk.runInfo.PanicCount
+	event.Extra["wwdk_StartAt"] = runInfo.StartAt
+	event.Extra["wwdk_SyncCount"] = runInfo.SyncCount
+	event.Extra["wwdk_ContactModifyCount"] = runInfo.ContactModifyCount
+	event.Extra["wwdk_MessageCount"] = runInfo.MessageCount
+	event.Extra["wwdk_PanicCount"] = runInfo.PanicCount
 	for _, extra := range extras {
 		event.Extra[extra.Key] = extra.Value
 	}
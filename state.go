@@ -0,0 +1,165 @@
+package wwdk
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ikuiki/wwdk/api"
+)
+
+// SessionState 会话状态
+type SessionState int32
+
+const (
+	// StateIdle 未登陆，刚创建或登陆失败后处于此状态
+	StateIdle SessionState = iota
+	// StateAwaitingScan 已获取二维码，等待用户扫码
+	StateAwaitingScan
+	// StateAwaitingConfirm 用户已扫码，等待用户在手机上确认登陆
+	StateAwaitingConfirm
+	// StateInitializing 用户已确认登陆，正在初始化并获取联系人
+	StateInitializing
+	// StateOnline 已登陆且同步正常
+	StateOnline
+	// StateDegraded 已登陆但同步出错，会话可能仍然有效
+	StateDegraded
+	// StateReconnecting 正在使用已保存的登陆信息恢复会话
+	StateReconnecting
+	// StateLoggedOut 用户已登出（主动退出、在手机上登出或在其他地方登陆）
+	StateLoggedOut
+	// StateExpired 登陆会话已失效，需要重新登陆
+	StateExpired
+)
+
+// String 返回状态的名字
+func (s SessionState) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateAwaitingScan:
+		return "AwaitingScan"
+	case StateAwaitingConfirm:
+		return "AwaitingConfirm"
+	case StateInitializing:
+		return "Initializing"
+	case StateOnline:
+		return "Online"
+	case StateDegraded:
+		return "Degraded"
+	case StateReconnecting:
+		return "Reconnecting"
+	case StateLoggedOut:
+		return "LoggedOut"
+	case StateExpired:
+		return "Expired"
+	default:
+		return "SessionState(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// StateTransition 一次会话状态变更
+type StateTransition struct {
+	From   SessionState // 变更前的状态
+	To     SessionState // 变更后的状态
+	At     time.Time    // 变更时间
+	Reason string       // 变更原因
+	Ret    int64        // 导致变更的微信错误码（如synccheck的retcode），没有时为0
+	Err    error        // 导致变更的错误，没有时为nil
+}
+
+// sessionState 会话状态机
+type sessionState struct {
+	mu          sync.Mutex
+	state       SessionState
+	nextID      int
+	subscribers map[int]chan<- StateTransition
+}
+
+// get 获取当前状态
+func (s *sessionState) get() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// set 变更状态并通知订阅者，状态未变化时不通知
+// 订阅者的channel已满时丢弃本次通知，不会阻塞状态变更
+// @return changed 状态是否发生了变化
+func (s *sessionState) set(to SessionState, reason string, err error) (changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == to {
+		return false
+	}
+	transition := StateTransition{
+		From:   s.state,
+		To:     to,
+		At:     time.Now(),
+		Reason: reason,
+		Ret:    retCode(err),
+		Err:    err,
+	}
+	s.state = to
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- transition:
+		default:
+		}
+	}
+	return true
+}
+
+// subscribe 订阅状态变更
+func (s *sessionState) subscribe(ch chan<- StateTransition) (unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[int]chan<- StateTransition)
+	}
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = ch
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// retCode 从错误链中找出微信返回的错误码
+func retCode(err error) int64 {
+	for err != nil {
+		apiErr, ok := api.AsAPIError(err)
+		if !ok {
+			return 0
+		}
+		if apiErr.Ret != 0 {
+			return apiErr.Ret
+		}
+		err = apiErr.Err
+	}
+	return 0
+}
+
+// State 获取当前的会话状态
+func (wxwb *WechatWeb) State() SessionState {
+	return wxwb.state.get()
+}
+
+// SubscribeState 订阅会话状态变更，每次状态变更时向ch发送一个StateTransition
+// 发送不会阻塞，ch已满时本次变更会被丢弃，因此ch应当带有足够的缓冲
+// ch不会被关闭，不再需要时调用返回的unsubscribe取消订阅
+func (wxwb *WechatWeb) SubscribeState(ch chan<- StateTransition) (unsubscribe func()) {
+	return wxwb.state.subscribe(ch)
+}
+
+// setState 变更会话状态
+// @param to 新的状态
+// @param reason 变更原因
+// @param err 导致变更的错误，没有时传nil
+func (wxwb *WechatWeb) setState(to SessionState, reason string, err error) {
+	if wxwb.state.set(to, reason, err) {
+		wxwb.logger.Infof("Session state changed to %s: %s\n", to, reason)
+	}
+}
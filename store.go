@@ -8,6 +8,7 @@ import (
 	"github.com/ikuiki/wwdk/api"
 	"github.com/ikuiki/wwdk/datastruct"
 	"github.com/pkg/errors"
+	"sync/atomic"
 )
 
 // storeLoginInfo 用于储存的登录信息
@@ -25,18 +26,43 @@ func (wxwb *WechatWeb) resetLoginInfo() (err error) {
 	if wxwb.loginStorer != nil {
 		wxwb.loginStorer.Truncate()
 	}
-	wxwb.api = api.MustNewWechatwebAPI()
+	wxwb.stopLoginNotify()
+	newAPI, err := wxwb.newAPI()
+	if err != nil {
+		panic(err)
+	}
+	// 重新登陆时调用方的协程可能正在调用公开方法，替换期间持有写锁
+	// 加锁顺序为先loginMutex后infoMutex
+	wxwb.loginMutex.Lock()
+	defer wxwb.loginMutex.Unlock()
+	wxwb.api = newAPI
 	// 重置runInfo
 	wxwb.runInfo = WechatRunInfo{
-		StartAt: wxwb.runInfo.StartAt,
+		StartAt:      wxwb.runInfo.StartAt,
+		ReloginCount: wxwb.runInfo.ReloginCount,
 	}
+	atomic.StoreUint64(&wxwb.retryCount, 0)
+	atomic.StoreUint64(&wxwb.circuitBreakCount, 0)
 	// 切记也要重置用户信息与联系人啊
+	wxwb.infoMutex.Lock()
+	defer wxwb.infoMutex.Unlock()
 	wxwb.userInfo = userInfo{
 		contactList: make(map[string]datastruct.Contact),
 	}
 	return nil
 }
 
+// stopLoginNotify 结束检测登陆信息修改的协程，并解除api上的通知管道
+// api发送通知时不会阻塞，因此不关闭通知管道本身，避免api向已关闭的管道发送
+func (wxwb *WechatWeb) stopLoginNotify() {
+	if wxwb.notifyStop == nil {
+		return
+	}
+	wxwb.currentAPI().SetLoginModifyNotifyChan(nil)
+	close(wxwb.notifyStop)
+	wxwb.notifyStop = nil
+}
+
 // 往storer中写入信息
 func (wxwb *WechatWeb) writeLoginInfo() (err error) {
 	defer func() {
@@ -47,22 +73,29 @@ func (wxwb *WechatWeb) writeLoginInfo() (err error) {
 			}
 			wxwb.captureException(eErr, "WriteLoginInfo panic", sentry.LevelError, extraData{"panicItem", r})
 			wxwb.logger.Infof("Recovered in writeLoginInfo: %v\n", r)
-			wxwb.runInfo.PanicCount++
+			wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+				runInfo.PanicCount++
+			})
 			err = errors.Errorf("panic recovered: %+v", r)
 		}
 	}()
-	apiMarshaled, err := wxwb.api.Marshal()
+	apiMarshaled, err := wxwb.currentAPI().Marshal()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	if wxwb.loginStorer != nil {
+		// 加锁顺序需要与resetLoginInfo一致，因此先获取runInfo再对userInfo加锁
+		runInfo := wxwb.GetRunInfo()
+		// 序列化联系人列表期间同步协程可能正在修改，需要持有读锁
+		wxwb.infoMutex.RLock()
 		storeInfo := storeLoginInfo{
 			APIMarshaled: apiMarshaled,
 			User:         wxwb.userInfo.user,
 			ContactList:  wxwb.userInfo.contactList,
-			RunInfo:      wxwb.runInfo,
+			RunInfo:      runInfo,
 		}
 		data, err := json.Marshal(storeInfo)
+		wxwb.infoMutex.RUnlock()
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -83,7 +116,9 @@ func (wxwb *WechatWeb) readLoginInfo() (readed bool, err error) {
 			}
 			wxwb.captureException(eErr, "ReadLoginInfo panic", sentry.LevelError, extraData{"panicItem", r})
 			wxwb.logger.Infof("Recovered in readLoginInfo: %v\n", r)
-			wxwb.runInfo.PanicCount++
+			wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+				runInfo.PanicCount++
+			})
 			err = errors.Errorf("panic recovered: %+v", r)
 		}
 	}()
@@ -101,7 +136,7 @@ func (wxwb *WechatWeb) readLoginInfo() (readed bool, err error) {
 		if err != nil {
 			return false, errors.WithStack(err)
 		}
-		err = wxwb.api.Unmarshal(storeInfo.APIMarshaled)
+		err = wxwb.currentAPI().Unmarshal(storeInfo.APIMarshaled)
 		if err != nil {
 			if err == api.ErrEmptyLoginInfo {
 				// api恢复时其内部关键信息为空
@@ -116,16 +151,18 @@ func (wxwb *WechatWeb) readLoginInfo() (readed bool, err error) {
 			return false, nil
 		}
 		// 认为读取到了登陆信息，则开始还原
-		{
+		wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
 			// 先暂存StartAt，对StartAt不做覆盖
-			started := wxwb.runInfo.StartAt
-			wxwb.runInfo = storeInfo.RunInfo
+			started := runInfo.StartAt
+			*runInfo = storeInfo.RunInfo
 			// 还原startat
-			wxwb.runInfo.StartAt = started
-		}
-		wxwb.userInfo.user = storeInfo.User
+			runInfo.StartAt = started
+		})
+		atomic.StoreUint64(&wxwb.retryCount, storeInfo.RunInfo.RetryCount)
+		atomic.StoreUint64(&wxwb.circuitBreakCount, storeInfo.RunInfo.CircuitBreakCount)
+		wxwb.setUser(storeInfo.User)
 		for _, contact := range storeInfo.ContactList {
-			wxwb.userInfo.contactList[contact.UserName] = contact
+			wxwb.putContacts(contact)
 		}
 		// 还原完成
 		return true, nil
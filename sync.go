@@ -1,6 +1,7 @@
 package wwdk
 
 import (
+	"context"
 	"github.com/getsentry/sentry-go"
 	"github.com/ikuiki/wwdk/api"
 	"time"
@@ -18,6 +19,11 @@ const (
 	SyncStatusModifyContact SyncStatus = 1
 	// SyncStatusNewMessage 同步状态：有新信息
 	SyncStatusNewMessage SyncStatus = 2
+	// SyncStatusModifySelf 同步状态：当前登陆用户的资料（昵称、头像等）有变更
+	SyncStatusModifySelf SyncStatus = 3
+	// SyncStatusRelogin 同步状态：开启了自动重新登陆，正在重新登陆，登陆进度见Login
+	// Login.Code为LoginStatusWaitForScan时需要扫码，登陆成功后同步继续进行
+	SyncStatusRelogin SyncStatus = 4
 	// SyncStatusPanic 致命错误，sync进程退出
 	SyncStatusPanic SyncStatus = -1
 	// SyncStatusErrorOccurred 非致命性错误发生，具体错误请参考Msg
@@ -29,43 +35,89 @@ type SyncChannelItem struct {
 	Code    SyncStatus          // 同步状态
 	Contact *datastruct.Contact // 联系人（如果同步状态是有联系人变更则有
 	Message *datastruct.Message // 新信息（如果同步状态是有新信息则有
+	User    *datastruct.User    // 当前登陆用户（如果同步状态是当前用户资料有变更则有
+	Login   *LoginChannelItem   // 重新登陆的进度（如果同步状态是重新登陆则有
 	Err     error               // 错误（如有发生
 	// Msg     string              // 其他附带信息
 }
 
 // StartServe 启动消息同步服务
 func (wxwb *WechatWeb) StartServe(syncChannel chan<- SyncChannelItem) {
+	wxwb.StartServeContext(context.Background(), syncChannel)
+}
+
+// StartServeContext 启动消息同步服务，ctx取消时同步服务退出并关闭syncChannel
+func (wxwb *WechatWeb) StartServeContext(ctx context.Context, syncChannel chan<- SyncChannelItem) {
 	wxwb.syncChannel = syncChannel
 	go func() {
 		// 方法结束时关闭channel
 		defer close(syncChannel)
 		getMessage := func() {
-			modContacts, delContacts, addMessage, body, err := wxwb.api.WebwxSync()
+			result, body, err := wxwb.currentAPI().WebwxSyncDetailContext(ctx)
 			if err != nil {
 				wxwb.captureException(err, "WebwxSync fatal", sentry.LevelError, extraData{"body", string(body)})
 				wxwb.logger.Infof("WebwxSync error: %s\n", err.Error())
-				return
+				wxwb.setState(StateDegraded, "webwxsync failed", err)
+				// 连续同步中途失败时result中仍有之前获取到的内容，需要继续处理
+			}
+			// 处理当前用户的资料变更
+			if result.Profile != nil {
+				if user, ok := wxwb.applyProfile(*result.Profile); ok {
+					wxwb.logger.Infof("Modify self profile: %s\n", user.NickName)
+					syncChannel <- SyncChannelItem{
+						Code: SyncStatusModifySelf,
+						User: &user,
+					}
+				}
 			}
 			// 处理新增联系人
-			for _, contact := range modContacts {
-				wxwb.runInfo.ContactModifyCount++
+			for _, contact := range result.ModContacts {
+				wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+					runInfo.ContactModifyCount++
+				})
 				wxwb.logger.Infof("Modify contact: %s\n", contact.NickName)
 				syncChannel <- SyncChannelItem{
 					Code:    SyncStatusModifyContact,
 					Contact: &contact,
 				}
-				wxwb.userInfo.contactList[contact.UserName] = contact
+				wxwb.putContacts(contact)
+			}
+			// 处理群成员变更
+			for _, mod := range result.ModChatRoomMembers {
+				mod := mod
+				chatroom, ok := wxwb.updateContact(mod.UserName, func(chatroom datastruct.Contact) datastruct.Contact {
+					chatroom = chatroom.UpdateMembers(mod.MemberList)
+					if mod.MemberCount > 0 {
+						chatroom.MemberCount = mod.MemberCount
+					}
+					return chatroom
+				})
+				if !ok {
+					continue
+				}
+				wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+					runInfo.ContactModifyCount++
+				})
+				wxwb.logger.Infof("Modify chatroom members: %s\n", chatroom.NickName)
+				syncChannel <- SyncChannelItem{
+					Code:    SyncStatusModifyContact,
+					Contact: &chatroom,
+				}
 			}
 			// 处理删除的联系人
-			for _, delContact := range delContacts {
-				delete(wxwb.userInfo.contactList, delContact.UserName)
+			for _, delContact := range result.DelContacts {
+				wxwb.deleteContact(delContact.UserName)
 			}
 			// 新消息
-			for _, msg := range addMessage {
+			for _, msg := range result.AddMessages {
 				if msg.MsgType == datastruct.RevokeMsg {
-					wxwb.runInfo.MessageRevokeCount++
+					wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+						runInfo.MessageRevokeCount++
+					})
 				} else {
-					wxwb.runInfo.MessageCount++
+					wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+						runInfo.MessageCount++
+					})
 				}
 				err = wxwb.messageProcesser(&msg, syncChannel)
 				if err != nil {
@@ -79,6 +131,8 @@ func (wxwb *WechatWeb) StartServe(syncChannel chan<- SyncChannelItem) {
 				}
 			}
 		}
+		// 连续同步失败的次数，用于计算失败后的等待时间
+		failCount := 0
 		for {
 			isBreaked := func() (isBreaked bool) {
 				defer func() {
@@ -89,31 +143,74 @@ func (wxwb *WechatWeb) StartServe(syncChannel chan<- SyncChannelItem) {
 						}
 						wxwb.captureException(eErr, "Sync loop panic", sentry.LevelError, extraData{"panicItem", r})
 						wxwb.logger.Infof("Recovered in Sync loop: %v\n", r)
-						wxwb.runInfo.PanicCount++
+						wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+							runInfo.PanicCount++
+						})
 						syncChannel <- SyncChannelItem{
 							Code: SyncStatusErrorOccurred,
 							Err:  errors.Errorf("recovered panic: %v", r),
 						}
 					}
 				}()
-				_, selector, body, err := wxwb.api.SyncCheck()
+				_, selector, body, err := wxwb.currentAPI().SyncCheckContext(ctx)
+				if ctx.Err() != nil {
+					// 同步服务被取消
+					wxwb.logger.Info("Sync canceled, exit...\n")
+					return true
+				}
 				if err != nil {
-					if err == api.ErrLogout {
+					if wxwb.autoRelogin != nil && api.IsSessionInvalid(err) && wxwb.State() != StateLoggedOut {
+						// 会话失效，自动重新登陆（主动退出登陆时除外）
+						wxwb.logger.Infof("Session invalid: %s, relogin...\n", err.Error())
+						wxwb.setState(StateReconnecting, "synccheck reported session invalid", err)
+						err = wxwb.relogin(ctx, syncChannel)
+						if ctx.Err() != nil {
+							return true
+						}
+						if err != nil {
+							wxwb.captureException(err, "Relogin fatal", sentry.LevelError)
+							syncChannel <- SyncChannelItem{
+								Code: SyncStatusPanic,
+								Err:  err,
+							}
+							return true
+						}
+						failCount = 0
+						return false
+					}
+					if api.IsLoggedOut(err) {
 						wxwb.logger.Info("User has logout web wechat, exit...\n")
+						wxwb.setState(StateLoggedOut, "synccheck reported logout", err)
 						syncChannel <- SyncChannelItem{
 							Code: SyncStatusPanic,
-							Err:  errors.New("Err1101: user has logout"),
+							Err:  errors.Wrap(err, "user has logout"),
 						}
 						return true
 					}
 					wxwb.captureException(err, "SyncCheck fatal", sentry.LevelError, extraData{"body", string(body)})
 					wxwb.logger.Infof("SyncCheck error: %s\n", err.Error())
+					if api.IsSessionInvalid(err) {
+						wxwb.setState(StateExpired, "synccheck reported session invalid", err)
+					} else {
+						wxwb.setState(StateDegraded, "synccheck failed", err)
+					}
 					syncChannel <- SyncChannelItem{
 						Code: SyncStatusErrorOccurred,
 						Err:  err,
 					}
+					// api层已经按照重试策略重试过，此处再按失败次数退避，避免持续失败时刷屏
+					failCount++
+					select {
+					case <-ctx.Done():
+						return true
+					case <-time.After(wxwb.retryPolicy.Backoff(failCount)):
+					}
 					return false
 				}
+				failCount = 0
+				if wxwb.State() == StateDegraded {
+					wxwb.setState(StateOnline, "synccheck recovered", nil)
+				}
 				// wxwb.logger.Infof("selector: %v\n", selector)
 				switch selector {
 				case "0":
@@ -149,8 +246,14 @@ func (wxwb *WechatWeb) StartServe(syncChannel chan<- SyncChannelItem) {
 						Err:  errors.Errorf("syncCheck unknow selector: %s", selector),
 					}
 				}
-				wxwb.runInfo.SyncCount++
-				time.Sleep(1000 * time.Millisecond)
+				wxwb.updateRunInfo(func(runInfo *WechatRunInfo) {
+					runInfo.SyncCount++
+				})
+				select {
+				case <-ctx.Done():
+					return true
+				case <-time.After(1000 * time.Millisecond):
+				}
 				return false
 			}()
 			if isBreaked {
@@ -0,0 +1,116 @@
+package wwdk
+
+// 此文件中为读写userInfo的方法
+// userInfo会被同步协程、登陆协程与调用方的协程同时访问，读写都必须持有infoMutex
+
+import (
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotLoggedIn 错误：当前没有登陆的用户，如尚未登陆或正在重新登陆
+	ErrNotLoggedIn = errors.New("not logged in")
+)
+
+// currentUser 获取当前登陆用户，未登陆时返回nil
+func (wxwb *WechatWeb) currentUser() *datastruct.User {
+	wxwb.infoMutex.RLock()
+	defer wxwb.infoMutex.RUnlock()
+	return wxwb.userInfo.user
+}
+
+// selfUserName 获取当前登陆用户的UserName，没有登陆的用户时返回ErrNotLoggedIn
+func (wxwb *WechatWeb) selfUserName() (userName string, err error) {
+	user := wxwb.currentUser()
+	if user == nil {
+		return "", ErrNotLoggedIn
+	}
+	return user.UserName, nil
+}
+
+// setUser 设置当前登陆用户
+func (wxwb *WechatWeb) setUser(user *datastruct.User) {
+	wxwb.infoMutex.Lock()
+	defer wxwb.infoMutex.Unlock()
+	wxwb.userInfo.user = user
+}
+
+// applyProfile 将资料变更应用到当前登陆用户
+// @return user 变更后的用户
+// @return ok 当前是否有登陆用户
+func (wxwb *WechatWeb) applyProfile(profile datastruct.Profile) (user datastruct.User, ok bool) {
+	wxwb.infoMutex.Lock()
+	defer wxwb.infoMutex.Unlock()
+	if wxwb.userInfo.user == nil {
+		return
+	}
+	user = wxwb.userInfo.user.ApplyProfile(profile)
+	wxwb.userInfo.user = &user
+	return user, true
+}
+
+// contact 从联系人列表中获取联系人
+func (wxwb *WechatWeb) contact(userName string) (contact datastruct.Contact, ok bool) {
+	wxwb.infoMutex.RLock()
+	defer wxwb.infoMutex.RUnlock()
+	contact, ok = wxwb.userInfo.contactList[userName]
+	return
+}
+
+// findContact 查找联系人列表中满足条件的联系人
+func (wxwb *WechatWeb) findContact(match func(contact datastruct.Contact) bool) (contact datastruct.Contact, ok bool) {
+	wxwb.infoMutex.RLock()
+	defer wxwb.infoMutex.RUnlock()
+	for _, v := range wxwb.userInfo.contactList {
+		if match(v) {
+			return v, true
+		}
+	}
+	return
+}
+
+// contacts 获取联系人列表的副本
+func (wxwb *WechatWeb) contacts() (contacts []datastruct.Contact) {
+	wxwb.infoMutex.RLock()
+	defer wxwb.infoMutex.RUnlock()
+	for _, v := range wxwb.userInfo.contactList {
+		contacts = append(contacts, v)
+	}
+	return
+}
+
+// putContacts 将联系人写入联系人列表，已存在的联系人会被覆盖
+func (wxwb *WechatWeb) putContacts(contacts ...datastruct.Contact) {
+	wxwb.infoMutex.Lock()
+	defer wxwb.infoMutex.Unlock()
+	for _, contact := range contacts {
+		wxwb.userInfo.contactList[contact.UserName] = contact
+	}
+}
+
+// deleteContact 从联系人列表中删除联系人
+func (wxwb *WechatWeb) deleteContact(userName string) {
+	wxwb.infoMutex.Lock()
+	defer wxwb.infoMutex.Unlock()
+	delete(wxwb.userInfo.contactList, userName)
+}
+
+// updateContact 修改联系人列表中已存在的联系人
+// 读取、修改、写回期间一直持有锁，避免与其他协程的修改互相覆盖
+// modify中不能再调用其他需要infoMutex的方法
+// @param userName 要修改的联系人的UserName
+// @param modify 修改函数，传入当前的联系人，返回修改后的联系人
+// @return contact 修改后的联系人
+// @return ok 联系人是否存在，不存在时不会调用modify
+func (wxwb *WechatWeb) updateContact(userName string, modify func(contact datastruct.Contact) datastruct.Contact) (contact datastruct.Contact, ok bool) {
+	wxwb.infoMutex.Lock()
+	defer wxwb.infoMutex.Unlock()
+	contact, ok = wxwb.userInfo.contactList[userName]
+	if !ok {
+		return
+	}
+	contact = modify(contact)
+	wxwb.userInfo.contactList[userName] = contact
+	return
+}
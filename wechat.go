@@ -4,7 +4,10 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/ikuiki/wwdk/api"
 	"github.com/pkg/errors"
+	"net/http"
 	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ikuiki/storer"
 	"github.com/kataras/golog"
@@ -38,6 +41,12 @@ type WechatRunInfo struct {
 	MessageRevokeSentCount uint64
 	// PanicCount panic计数器
 	PanicCount uint64
+	// RetryCount 请求失败重试计数器
+	RetryCount uint64
+	// CircuitBreakCount 接口因操作频繁被熔断的计数器
+	CircuitBreakCount uint64
+	// ReloginCount 同步时自动重新登陆成功的计数器
+	ReloginCount uint64
 }
 
 // userInfo 微信用户信息，包含用户、联系人列表等信息
@@ -48,33 +57,47 @@ type userInfo struct {
 
 // WechatWeb 微信网页版客户端实例
 type WechatWeb struct {
-	userInfo    userInfo               // 用户信息
-	api         api.WechatwebAPI       // 微信网页版的api实现
-	runInfo     WechatRunInfo          // 运行统计信息
+	// retryCount与circuitBreakCount在api的重试回调中更新，回调可能发生在任意协程，需要通过atomic读写
+	// 放在结构体开头以保证32位平台上atomic操作要求的64位对齐
+	retryCount        uint64
+	circuitBreakCount uint64
+
+	userInfo    userInfo               // 用户信息，需要通过userinfo.go中的方法读写
+	infoMutex   sync.RWMutex           // 保护userInfo的读写锁
+	api         api.WechatwebAPI       // 微信网页版的api实现，需要通过currentAPI获取
+	runInfo     WechatRunInfo          // 运行统计信息，需要通过updateRunInfo修改
+	loginMutex  sync.RWMutex           // 保护api与runInfo的读写锁，重置登陆信息时会整体替换两者
 	loginStorer storer.Storer          // 存储器，如果有赋值，则用于记录登录信息
 	logger      *golog.Logger          // 日志输出器
 	mediaStorer MediaStorer            // 媒体存储器，用于处理微信的媒体信息（如用户头像、发送的图片、视频、音频等
 	syncChannel chan<- SyncChannelItem // 同步通道，方便除sync方法外发生同步
 	sentryHub   *sentry.Hub            // 用来进行错误追踪的hub，bindClient后生效
+	apiConfigs  []interface{}          // 创建api时使用的配置，重置登陆信息重新创建api时需要沿用
+	customAPI   api.WechatwebAPI       // 通过配置传入的api实现（如测试用的FakeAPI），如有则不再自行创建api
+	retryPolicy *api.RetryPolicy       // 请求失败时的重试策略，同时用于同步失败后的等待
+	sendLimiter *sendLimiter           // 发送消息的限速器
+	state       sessionState           // 会话状态机
+	autoRelogin *AutoRelogin           // 自动重新登陆的配置，为nil时不自动重新登陆
+	notifyStop  chan struct{}          // 关闭后结束当前保存登陆信息的协程，重新登陆时需要先结束上一个
+	// pushLoginTimeout 推送登陆后等待用户确认的最长时间
+	pushLoginTimeout time.Duration
 }
 
 // NewWechatWeb 生成微信网页版客户端实例
 func NewWechatWeb(configs ...interface{}) (wxweb *WechatWeb, err error) {
-	a, err := api.NewWechatwebAPI()
-	if err != nil {
-		return nil, err
-	}
 	w := &WechatWeb{
 		userInfo: userInfo{
 			contactList: make(map[string]datastruct.Contact),
 		},
-		api: a,
 		runInfo: WechatRunInfo{
 			StartAt: time.Now(),
 		},
-		logger:      golog.Default.Clone(),
-		mediaStorer: NewLocalMediaStorer("./"),
-		sentryHub:   sentry.NewHub(nil, sentry.NewScope()),
+		logger:           golog.Default.Clone(),
+		mediaStorer:      NewLocalMediaStorer("./"),
+		sentryHub:        sentry.NewHub(nil, sentry.NewScope()),
+		retryPolicy:      api.DefaultRetryPolicy(),
+		sendLimiter:      newSendLimiter(*DefaultSendLimit()),
+		pushLoginTimeout: defaultPushLoginTimeout,
 	}
 	{
 		w.sentryHub.Scope().SetTags(map[string]string{
@@ -99,11 +122,81 @@ func NewWechatWeb(configs ...interface{}) (wxweb *WechatWeb, err error) {
 			w.mediaStorer = c.(MediaStorer)
 		case *sentry.Client:
 			w.sentryHub.BindClient(c.(*sentry.Client))
+		case api.EndpointResolver:
+			w.sentryHub.Scope().SetExtra("endpointResolver", reflect.TypeOf(c).String())
+			w.apiConfigs = append(w.apiConfigs, c)
+		case api.Timeout:
+			w.apiConfigs = append(w.apiConfigs, c)
+		case api.ProxyURL:
+			// 不记录代理地址，其中可能包含认证信息
+			w.sentryHub.Scope().SetExtra("proxy", true)
+			w.apiConfigs = append(w.apiConfigs, c)
+		case *SendLimit:
+			w.sendLimiter = newSendLimiter(*c.(*SendLimit))
+		case *api.RetryPolicy:
+			w.retryPolicy = c.(*api.RetryPolicy)
+		case *AutoRelogin:
+			w.autoRelogin = c.(*AutoRelogin)
+		case PushLoginTimeout:
+			w.pushLoginTimeout = time.Duration(c.(PushLoginTimeout))
+		case api.WechatwebAPI:
+			w.sentryHub.Scope().SetExtra("wechatwebAPI", reflect.TypeOf(c).String())
+			w.customAPI = c.(api.WechatwebAPI)
+		case http.RoundTripper:
+			w.sentryHub.Scope().SetExtra("transport", reflect.TypeOf(c).String())
+			w.apiConfigs = append(w.apiConfigs, c)
 		default:
 			err = errors.Errorf("unknown config type(%s): %#v", reflect.TypeOf(c).String(), c)
 			w.captureException(err, "Unknown wwdk config", sentry.LevelWarning)
 			return nil, err
 		}
 	}
+	w.api, err = w.newAPI()
+	if err != nil {
+		return nil, err
+	}
 	return w, nil
 }
+
+// newAPI 创建api，如果通过配置传入了api实现则沿用该实现
+func (wxwb *WechatWeb) newAPI() (wechatAPI api.WechatwebAPI, err error) {
+	if wxwb.customAPI != nil {
+		return wxwb.customAPI, nil
+	}
+	// 复制一份重试策略，加入日志与统计
+	policy := *wxwb.retryPolicy
+	policy.OnAttempt = func(attempt api.RetryAttempt) {
+		if attempt.Retrying {
+			atomic.AddUint64(&wxwb.retryCount, 1)
+			wxwb.logger.Infof("Request %s attempt %d failed(status %d): %v, retry after %v\n", attempt.Endpoint, attempt.Attempt, attempt.StatusCode, attempt.Err, attempt.Delay)
+		}
+		if wxwb.retryPolicy.OnAttempt != nil {
+			wxwb.retryPolicy.OnAttempt(attempt)
+		}
+	}
+	policy.OnCircuitBreak = func(endpoint string, cooldown time.Duration) {
+		atomic.AddUint64(&wxwb.circuitBreakCount, 1)
+		wxwb.captureException(nil, "Request circuit break", sentry.LevelWarning, extraData{"endpoint", endpoint})
+		wxwb.logger.Warnf("Request %s rate limited, circuit break for %v\n", endpoint, cooldown)
+		if wxwb.retryPolicy.OnCircuitBreak != nil {
+			wxwb.retryPolicy.OnCircuitBreak(endpoint, cooldown)
+		}
+	}
+	configs := append([]interface{}{&policy}, wxwb.apiConfigs...)
+	return api.NewWechatwebAPI(configs...)
+}
+
+// currentAPI 获取当前的api实现，重新登陆后会被替换
+func (wxwb *WechatWeb) currentAPI() api.WechatwebAPI {
+	wxwb.loginMutex.RLock()
+	defer wxwb.loginMutex.RUnlock()
+	return wxwb.api
+}
+
+// updateRunInfo 修改运行统计信息
+// modify中不能再调用其他需要loginMutex的方法
+func (wxwb *WechatWeb) updateRunInfo(modify func(runInfo *WechatRunInfo)) {
+	wxwb.loginMutex.Lock()
+	defer wxwb.loginMutex.Unlock()
+	modify(&wxwb.runInfo)
+}
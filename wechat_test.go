@@ -0,0 +1,603 @@
+package wwdk_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ikuiki/wwdk"
+	"github.com/ikuiki/wwdk/api"
+	"github.com/ikuiki/wwdk/api/apitest"
+	"github.com/ikuiki/wwdk/datastruct"
+	"github.com/pkg/errors"
+)
+
+func TestWechatWebWithFakeAPI(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.AddContact(
+		datastruct.Contact{UserName: "@friend", NickName: "friend"},
+		datastruct.Contact{UserName: "@@room", NickName: "room", MemberList: []datastruct.Member{
+			datastruct.Member{UserName: "@self"},
+			datastruct.Member{UserName: "@friend"},
+		}},
+	)
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	var client wwdk.Client = wx
+	loginChan := make(chan wwdk.LoginChannelItem)
+	client.Login(loginChan)
+	for item := range loginChan {
+		if item.Code == wwdk.LoginStatusErrorOccurred {
+			t.Fatalf("login error: %v", item.Err)
+		}
+	}
+	room, err := client.GetContact("@@room")
+	if err != nil || len(room.MemberList) != 2 {
+		t.Fatalf("expect chatroom with 2 members, got %#v(%v)", room, err)
+	}
+
+	syncChan := make(chan wwdk.SyncChannelItem)
+	client.StartServe(syncChan)
+	fake.InjectMessage(datastruct.Message{
+		FromUserName: "@friend",
+		MsgType:      datastruct.TextMsg,
+		Content:      "ping",
+	})
+	select {
+	case item := <-syncChan:
+		if item.Code != wwdk.SyncStatusNewMessage || item.Message.Content != "ping" {
+			t.Fatalf("expect new message ping, got %#v", item)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait for message timeout")
+	}
+
+	msgID, localID, err := client.SendTextMessage("@friend", "pong")
+	if err != nil {
+		t.Fatalf("SendTextMessage error: %v", err)
+	}
+	err = client.SendRevokeMessage(msgID, localID, "@friend")
+	if err != nil {
+		t.Fatalf("SendRevokeMessage error: %v", err)
+	}
+	err = client.ModifyChatRoomTopic("@@room", "new room")
+	if err != nil {
+		t.Fatalf("ModifyChatRoomTopic error: %v", err)
+	}
+	// 被邀请的联系人接受邀请前不是群成员
+	err = client.InviteChatroomMember("@@room", []string{"@invitee"})
+	if err != nil {
+		t.Fatalf("InviteChatroomMember error: %v", err)
+	}
+	if room, _ := client.GetContact("@@room"); len(room.MemberList) != 2 {
+		t.Fatalf("expect chatroom members unchanged after invite, got %#v", room.MemberList)
+	}
+	if room, _ := fake.Contact("@@room"); len(room.MemberList) != 2 {
+		t.Fatalf("expect fake chatroom members unchanged after invite, got %#v", room.MemberList)
+	}
+	sent := fake.SentMessages()
+	if len(sent) != 1 || sent[0].Msg.Content != "pong" || sent[0].Msg.FromUserName != "@self" {
+		t.Fatalf("unexpected sent messages: %#v", sent)
+	}
+	if revoked := fake.RevokedMessages(); len(revoked) != 1 || revoked[0].SvrMsgID != msgID {
+		t.Fatalf("unexpected revoked messages: %#v", revoked)
+	}
+	if topics := fake.TopicModifications(); len(topics) != 1 || topics[0].NewTopic != "new room" {
+		t.Fatalf("unexpected topic modifications: %#v", topics)
+	}
+
+	fake.ForceLogout()
+	for item := range syncChan {
+		if item.Code == wwdk.SyncStatusPanic {
+			return
+		}
+	}
+	t.Fatal("expect SyncStatusPanic after logout")
+}
+
+func TestStartServeContextCancel(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.PollTimeout = time.Minute
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for range loginChan {
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	syncChan := make(chan wwdk.SyncChannelItem)
+	wx.StartServeContext(ctx, syncChan)
+	cancel()
+	select {
+	case item, ok := <-syncChan:
+		if ok {
+			t.Fatalf("expect syncChannel closed, got %#v", item)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync not stopped after cancel")
+	}
+
+	_, _, err = wx.SendTextMessageContext(ctx, "@friend", "pong")
+	if err == nil {
+		t.Fatal("expect error with canceled context")
+	}
+}
+
+func TestSendLimit(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.AddContact(datastruct.Contact{UserName: "@friend", NickName: "friend"})
+	wx, err := wwdk.NewWechatWeb(fake, &wwdk.SendLimit{
+		RecipientRate:  20,
+		RecipientBurst: 1,
+		ChatroomRate:   0.1,
+		ChatroomBurst:  1,
+	})
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for range loginChan {
+	}
+
+	// 阻塞调用会等待配额
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, _, err = wx.SendTextMessage("@friend", "hi")
+		if err != nil {
+			t.Fatalf("SendTextMessage error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expect sends paced, elapsed %v", elapsed)
+	}
+
+	// 带截止时间的调用在无法及时获取配额时立即失败
+	_, _, err = wx.SendTextMessage("@@room", "hi")
+	if err != nil {
+		t.Fatalf("SendTextMessage to chatroom error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start = time.Now()
+	_, _, err = wx.SendTextMessageContext(ctx, "@@room", "hi")
+	if errors.Cause(err) != wwdk.ErrSendRateLimited || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("expect fail fast with ErrSendRateLimited, got %v", err)
+	}
+	if sent := fake.SentMessages(); len(sent) != 4 {
+		t.Fatalf("expect 4 messages sent, got %d", len(sent))
+	}
+}
+
+func TestWechatWebWithProxy(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.SetAutoConfirm(true)
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, path.Base(r.URL.Path))
+		mu.Unlock()
+		// 代理收到的是完整地址，直接转发
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	_, err := wwdk.NewWechatWeb(srv.EndpointResolver(), api.ProxyURL("ftp://127.0.0.1"))
+	if err == nil {
+		t.Fatal("expect error with unsupported proxy scheme")
+	}
+	wx, err := wwdk.NewWechatWeb(srv.EndpointResolver(), api.ProxyURL(proxy.URL))
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	// 登陆时会重置登陆信息并重新创建api，代理配置需要保留
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for item := range loginChan {
+		if item.Code == wwdk.LoginStatusErrorOccurred {
+			t.Fatalf("login error: %v", item.Err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) == 0 || len(proxied) != len(srv.RequestPaths()) {
+		t.Fatalf("expect all requests through proxy, proxied %v, server got %v", proxied, srv.RequestPaths())
+	}
+}
+
+func TestLoginContactPagination(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.ContactPageSize = 2
+	fake.AddContact(
+		datastruct.Contact{UserName: "@a", NickName: "a"},
+		datastruct.Contact{UserName: "@b", NickName: "b"},
+		datastruct.Contact{UserName: "@c", NickName: "c"},
+	)
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	var progress []string
+	for item := range loginChan {
+		switch item.Code {
+		case wwdk.LoginStatusErrorOccurred:
+			t.Fatalf("login error: %v", item.Err)
+		case wwdk.LoginStatusGettingContact:
+			progress = append(progress, item.Msg)
+		}
+	}
+	if len(progress) != 2 || progress[0] != "2" || progress[1] != "3" {
+		t.Fatalf("expect contact progress [2 3], got %v", progress)
+	}
+	if contacts := wx.GetContactList(); len(contacts) != 3 {
+		t.Fatalf("expect 3 contacts, got %d", len(contacts))
+	}
+}
+
+func TestLoginBatchGetContactChunks(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.BatchGetContactLimit = 50
+	// 第一个分块失败，只记录日志，不影响其余分块与登陆
+	fake.BatchGetContactFailures = 1
+	for i := 0; i < 120; i++ {
+		fake.AddContact(datastruct.Contact{
+			UserName:   fmt.Sprintf("@@room%d", i),
+			NickName:   fmt.Sprintf("room%d", i),
+			MemberList: []datastruct.Member{{UserName: "@self"}, {UserName: fmt.Sprintf("@member%d", i)}},
+		})
+	}
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for item := range loginChan {
+		if item.Code == wwdk.LoginStatusErrorOccurred {
+			t.Fatalf("login error: %v", item.Err)
+		}
+	}
+	contacts := wx.GetContactList()
+	if len(contacts) != 120 {
+		t.Fatalf("expect 120 contacts, got %d", len(contacts))
+	}
+	fetched := 0
+	for _, contact := range contacts {
+		switch len(contact.MemberList) {
+		case 2:
+			fetched++
+		case 0:
+		default:
+			t.Fatalf("chatroom %s expect 0 or 2 members, got %d", contact.UserName, len(contact.MemberList))
+		}
+	}
+	if fetched != 70 {
+		t.Fatalf("expect 70 chatrooms with members after the first chunk failed, got %d", fetched)
+	}
+}
+
+func TestSyncProfileAndChatRoomMembers(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.AddContact(datastruct.Contact{
+		UserName:   "@@room",
+		NickName:   "room",
+		MemberList: []datastruct.Member{{UserName: "@self", NickName: "self"}},
+	})
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for range loginChan {
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	syncChan := make(chan wwdk.SyncChannelItem)
+	wx.StartServeContext(ctx, syncChan)
+
+	fake.ModifyProfile(datastruct.Profile{NickName: &datastruct.BuffString{Buff: "newself"}})
+	fake.ModifyChatRoomMembers("@@room",
+		datastruct.Member{UserName: "@self", DisplayName: "me"},
+		datastruct.Member{UserName: "@friend", NickName: "friend"},
+	)
+	var gotSelf, gotRoom bool
+	timeout := time.After(5 * time.Second)
+	for !gotSelf || !gotRoom {
+		select {
+		case item := <-syncChan:
+			switch item.Code {
+			case wwdk.SyncStatusModifySelf:
+				if item.User.NickName != "newself" {
+					t.Fatalf("expect self nickname newself, got %s", item.User.NickName)
+				}
+				gotSelf = true
+			case wwdk.SyncStatusModifyContact:
+				if len(item.Contact.MemberList) != 2 || item.Contact.MemberList[0].DisplayName != "me" {
+					t.Fatalf("expect updated members, got %#v", item.Contact.MemberList)
+				}
+				gotRoom = true
+			}
+		case <-timeout:
+			t.Fatalf("sync events not received, self %v, room %v", gotSelf, gotRoom)
+		}
+	}
+	// 等待同步退出后再读取，避免与同步协程竞争
+	cancel()
+	for range syncChan {
+	}
+	if user, _ := wx.GetUser(); user.NickName != "newself" {
+		t.Fatalf("expect user nickname updated, got %s", user.NickName)
+	}
+	if room, _ := wx.GetContact("@@room"); room.MemberCount != 2 {
+		t.Fatalf("expect chatroom member count 2, got %d", room.MemberCount)
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	if wx.State() != wwdk.StateIdle {
+		t.Fatalf("expect initial state Idle, got %s", wx.State())
+	}
+	stateChan := make(chan wwdk.StateTransition, 16)
+	unsubscribe := wx.SubscribeState(stateChan)
+	defer unsubscribe()
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for range loginChan {
+	}
+	if wx.State() != wwdk.StateOnline {
+		t.Fatalf("expect state Online after login, got %s", wx.State())
+	}
+	syncChan := make(chan wwdk.SyncChannelItem)
+	wx.StartServe(syncChan)
+	fake.ForceLogout()
+	for range syncChan {
+	}
+	var got []wwdk.SessionState
+	var last wwdk.StateTransition
+	for len(stateChan) > 0 {
+		last = <-stateChan
+		got = append(got, last.To)
+	}
+	expect := []wwdk.SessionState{wwdk.StateAwaitingScan, wwdk.StateInitializing, wwdk.StateOnline, wwdk.StateLoggedOut}
+	if fmt.Sprint(got) != fmt.Sprint(expect) {
+		t.Fatalf("expect transitions %v, got %v", expect, got)
+	}
+	if last.From != wwdk.StateOnline || last.Ret != api.RetLoginElsewhere || last.Err == nil {
+		t.Fatalf("expect logout transition with ret 1101, got %#v", last)
+	}
+}
+
+func TestAutoRelogin(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	fake.AddContact(datastruct.Contact{UserName: "@friend", NickName: "friend"})
+	wx, err := wwdk.NewWechatWeb(fake, &wwdk.AutoRelogin{MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	loginChan := make(chan wwdk.LoginChannelItem)
+	wx.Login(loginChan)
+	for range loginChan {
+	}
+	syncChan := make(chan wwdk.SyncChannelItem)
+	wx.StartServe(syncChan)
+	// 重新登陆期间调用方仍在调用公开方法，配合-race检查重置登陆信息时的并发读写
+	callerStop := make(chan struct{})
+	callerDone := make(chan struct{})
+	go func() {
+		defer close(callerDone)
+		for {
+			select {
+			case <-callerStop:
+				return
+			default:
+			}
+			wx.GetUser()
+			wx.GetContactList()
+			wx.GetRunInfo()
+			wx.StatusNotify("@friend", 1)
+		}
+	}()
+	fake.ForceLogout()
+	timeout := time.After(5 * time.Second)
+	var loginCodes []wwdk.LoginStatus
+	for relogined := false; !relogined; {
+		select {
+		case item, ok := <-syncChan:
+			if !ok {
+				t.Fatal("syncChannel closed before relogin")
+			}
+			switch item.Code {
+			case wwdk.SyncStatusRelogin:
+				loginCodes = append(loginCodes, item.Login.Code)
+				relogined = item.Login.Code == wwdk.LoginStatusBatchGotContact
+			case wwdk.SyncStatusPanic:
+				t.Fatalf("sync panic: %v", item.Err)
+			}
+		case <-timeout:
+			t.Fatalf("relogin not finished, got login codes %v", loginCodes)
+		}
+	}
+	close(callerStop)
+	<-callerDone
+	if loginCodes[0] != wwdk.LoginStatusWaitForPushConfirm {
+		t.Fatalf("expect relogin through push login, got login codes %v", loginCodes)
+	}
+
+	// 重新登陆后同步继续在原来的channel上进行
+	fake.InjectMessage(datastruct.Message{
+		FromUserName: "@friend",
+		MsgType:      datastruct.TextMsg,
+		Content:      "after relogin",
+	})
+	for received := false; !received; {
+		select {
+		case item := <-syncChan:
+			received = item.Code == wwdk.SyncStatusNewMessage && item.Message.Content == "after relogin"
+		case <-timeout:
+			t.Fatal("message after relogin not received")
+		}
+	}
+	if wx.State() != wwdk.StateOnline {
+		t.Fatalf("expect Online after relogin, got %s", wx.State())
+	}
+
+	// 主动退出登陆时不自动重新登陆
+	if err = wx.Logout(); err != nil {
+		t.Fatalf("Logout error: %v", err)
+	}
+	for item := range syncChan {
+		if item.Code == wwdk.SyncStatusRelogin {
+			t.Fatal("expect no relogin after Logout")
+		}
+	}
+	if count := wx.GetRunInfo().ReloginCount; count != 1 {
+		t.Fatalf("expect 1 relogin, got %d", count)
+	}
+}
+
+func TestPushLogin(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	wx, err := wwdk.NewWechatWeb(fake)
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	login := func() (codes []wwdk.LoginStatus) {
+		loginChan := make(chan wwdk.LoginChannelItem)
+		wx.Login(loginChan)
+		for item := range loginChan {
+			if item.Code == wwdk.LoginStatusErrorOccurred {
+				t.Fatalf("login error: %v", item.Err)
+			}
+			codes = append(codes, item.Code)
+		}
+		return
+	}
+	// 第一次登陆没有上次登陆的uin，只能扫码
+	if codes := login(); codes[0] != wwdk.LoginStatusWaitForScan {
+		t.Fatalf("expect first login through qrcode, got login codes %v", codes)
+	}
+	// 再次登陆时推送到手机确认
+	if codes := login(); codes[0] != wwdk.LoginStatusWaitForPushConfirm || codes[1] != wwdk.LoginStatusScanedFinish {
+		t.Fatalf("expect login through push, got login codes %v", codes)
+	}
+	// 推送被拒绝时改为扫码
+	fake.PushLoginRefused = true
+	if codes := login(); codes[0] != wwdk.LoginStatusWaitForPushConfirm || codes[1] != wwdk.LoginStatusWaitForScan {
+		t.Fatalf("expect fallback to qrcode after push refused, got login codes %v", codes)
+	}
+	if wx.State() != wwdk.StateOnline {
+		t.Fatalf("expect Online after login, got %s", wx.State())
+	}
+}
+
+func TestPushLoginTimeout(t *testing.T) {
+	fake := apitest.NewFakeAPI()
+	wx, err := wwdk.NewWechatWeb(fake, wwdk.PushLoginTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewWechatWeb error: %v", err)
+	}
+	login := func() (codes []wwdk.LoginStatus) {
+		loginChan := make(chan wwdk.LoginChannelItem)
+		wx.Login(loginChan)
+		for item := range loginChan {
+			if item.Code == wwdk.LoginStatusErrorOccurred {
+				t.Fatalf("login error: %v", item.Err)
+			}
+			codes = append(codes, item.Code)
+		}
+		return
+	}
+	login()
+	// 用户一直不确认推送，超时后改为扫码
+	fake.PushLoginIgnored = true
+	start := time.Now()
+	if codes := login(); codes[0] != wwdk.LoginStatusWaitForPushConfirm || codes[1] != wwdk.LoginStatusWaitForScan {
+		t.Fatalf("expect fallback to qrcode after push timeout, got login codes %v", codes)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expect push login to time out after PushLoginTimeout, took %s", elapsed)
+	}
+	if wx.State() != wwdk.StateOnline {
+		t.Fatalf("expect Online after login, got %s", wx.State())
+	}
+}
+
+// failingReader 读取一部分数据后返回错误，模拟下载中断
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (n int, err error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n = copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestLocalMediaStreamStorer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wwdk")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	storer := wwdk.NewLocalMediaStorer(dir + "/").(wwdk.MediaStreamStorer)
+	_, err = storer.StreamStorer(wwdk.MediaStreamFile{
+		FileName: "broken.jpg",
+		Content:  &failingReader{data: []byte("partial")},
+	})
+	if err == nil {
+		t.Fatal("expect error when stream is interrupted")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expect no file left after interrupted stream, got %d", len(files))
+	}
+	url, err := storer.StreamStorer(wwdk.MediaStreamFile{
+		FileName: "ok.jpg",
+		Content:  strings.NewReader("complete"),
+	})
+	if err != nil {
+		t.Fatalf("StreamStorer error: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(url); string(data) != "complete" {
+		t.Fatalf("expect stored content complete, got %q", data)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expect only stored file in dir, got %d", len(files))
+	}
+}